@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mroobert/json-api/internal/logger"
+)
+
+// Formats of the access log.
+const (
+	accessLogJSON     = "json"
+	accessLogCombined = "combined" // Apache combined log format
+	accessLogOff      = "off"
+)
+
+// accessLog middleware writes a line per request to the logger, with the status, the size
+// and the duration of the response. The paths of the skip list aren't logged and only a
+// sample of the 2xx responses is, as configured.
+func (app *application) accessLog(next http.Handler) http.Handler {
+	skip := make(map[string]bool, len(app.config.accessLog.skip))
+	for _, path := range app.config.accessLog.skip {
+		skip[path] = true
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if app.config.accessLog.format == accessLogOff || skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.Status()
+		if status >= 200 && status < 300 && rand.Float64() >= app.config.accessLog.sample2xx {
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if app.config.accessLog.format == accessLogCombined {
+			app.logger.PrintRaw(combinedLogLine(r, ip, start, status, rec.size))
+			return
+		}
+
+		// The pattern is set by routePattern, on the pointer stored by recordMetrics.
+		route := unmatchedRoute
+		if pattern, ok := r.Context().Value(routeContextKey).(*string); ok {
+			route = *pattern
+		}
+
+		app.contextLogger(r).Info("request completed",
+			logger.String("method", r.Method),
+			logger.String("route", route),
+			logger.String("path", r.URL.Path),
+			logger.Int("status", status),
+			logger.Int64("size", rec.size),
+			logger.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			logger.String("remote_ip", ip),
+			logger.String("user_agent", r.UserAgent()),
+		)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// combinedLogLine formats the request in the Apache combined log format:
+//
+//	host - user [time] "request line" status size "referer" "user agent"
+//
+// The user isn't known at this point, so it's always "-".
+func combinedLogLine(r *http.Request, ip string, start time.Time, status int, size int64) string {
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	var b strings.Builder
+	b.WriteString(ip)
+	b.WriteString(" - - [")
+	b.WriteString(start.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString("] ")
+	b.WriteString(quoteLogField(r.Method + " " + r.URL.RequestURI() + " " + r.Proto))
+	b.WriteString(" " + strconv.Itoa(status) + " " + bytes + " ")
+	b.WriteString(quoteLogField(r.Referer()))
+	b.WriteByte(' ')
+	b.WriteString(quoteLogField(r.UserAgent()))
+
+	return b.String()
+}
+
+// quoteLogField quotes a field of the combined log format, escaping the quotes and the
+// non-printable characters. The empty fields are written as "-".
+func quoteLogField(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/logger"
+)
+
+// contextKey is used for the keys of the values stored in the request context.
+// Using a custom type avoids collisions with keys defined by other packages.
+type contextKey string
+
+const (
+	userContextKey      = contextKey("user")
+	routeContextKey     = contextKey("route") // Pattern of the route, set by routePattern
+	requestIDContextKey = contextKey("request_id")
+)
+
+// contextSetUser returns a new copy of the request with the provided
+// User struct added to the context.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser retrieves the User struct from the request context.
+// The only time that we'll use this helper is when we logically expect there to be
+// a User struct value in the context, so if it doesn't exist it will be considered
+// an 'unexpected' error and we panic.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// contextSetRequestID returns a new copy of the request with the request ID added to
+// the context, along with a child of the application logger which logs the ID.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	ctx = logger.NewContext(ctx, app.logger.With(map[string]string{"request_id": id}))
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID retrieves the request ID from the request context.
+// It's empty for the requests which didn't go through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextLogger returns the logger of the request, which logs the request ID.
+func (app *application) contextLogger(r *http.Request) *logger.Logger {
+	return app.logger.FromContext(r.Context())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// createCreditHandler for the "POST /v1/movies/:id/credits" endpoint.
+func (app *application) createCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.repositories.Movies.Read(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input data.NewCredit
+	err = web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := data.Credit{MovieID: movieID}
+	credit.FromNewCredit(input)
+
+	vld := validator.New()
+	if credit.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Credits.Create(r.Context(), &credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			vld.AddError("person_id", "person does not exist")
+			app.failedValidationResponse(w, r, vld.Errors)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			vld.AddError("person_id", "person is already credited in this role")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCreditHandler for the "DELETE /v1/movies/:id/credits/:credit_id" endpoint.
+func (app *application) deleteCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id, err := web.ReadInt64Param(r, "credit_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repositories.Credits.Delete(r.Context(), movieID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
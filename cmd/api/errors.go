@@ -1,15 +1,52 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/mroobert/json-api/internal/data"
 	"github.com/mroobert/json-api/internal/web"
 )
 
-// logError method is a generic helper for logging an error message.
+// problemTypeBase is the base URI of the problem types; the error code is appended to it.
+const problemTypeBase = "https://jsonapi.mroobert.net/problems/"
+
+// Error codes are the stable, machine-readable identifiers of the error responses.
+const (
+	codeServerError                = "server_error"
+	codeClientClosedRequest        = "client_closed_request"
+	codeServiceUnavailable         = "service_unavailable"
+	codeBadRequest                 = "bad_request"
+	codeNotFound                   = "not_found"
+	codeMethodNotAllowed           = "method_not_allowed"
+	codeFailedValidation           = "failed_validation"
+	codeEditConflict               = "edit_conflict"
+	codeRateLimitExceeded          = "rate_limit_exceeded"
+	codeInvalidCredentials         = "invalid_credentials"
+	codeInvalidAuthenticationToken = "invalid_authentication_token"
+	codeAuthenticationRequired     = "authentication_required"
+	codeInactiveAccount            = "inactive_account"
+	codeNotPermitted               = "not_permitted"
+	codePreconditionFailed         = "precondition_failed"
+	codePreconditionRequired       = "precondition_required"
+	codeUnsupportedMediaType       = "unsupported_media_type"
+	codeNotAcceptable              = "not_acceptable"
+	codeRequestTooLarge            = "request_too_large"
+	codePatchTestFailed            = "patch_test_failed"
+	codeIdempotencyKeyReused       = "idempotency_key_reused"
+	codeIdempotencyKeyInFlight     = "idempotency_key_in_flight"
+)
+
+// statusClientClosedRequest is the non-standard status code (introduced by nginx)
+// used when the client closed the connection before the server could respond.
+const statusClientClosedRequest = 499
+
+// logError method is a generic helper for logging an error message,
+// with the ID of the request.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.contextLogger(r).PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -17,55 +54,220 @@ func (app *application) logError(r *http.Request, err error) {
 
 // errorResponse method is a generic helper for sending JSON-formatted error
 // messages to the client with a given status code.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	envelope := web.Envelope{"error": message}
+// Clients accepting "application/problem+json" get an RFC 7807 problem details
+// object with a stable machine-readable code, the others get the {"error": message} envelope.
+// Both carry the ID of the request, so the clients can report it.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	var err error
+
+	// The format of the error depends on the Accept header of the request.
+	w.Header().Add("Vary", "Accept")
+
+	if web.Accepts(r, web.ProblemContentType) {
+		problem := newProblem(r, status, code, message)
+		problem.RequestID = app.contextGetRequestID(r)
+		err = web.WriteProblem(w, problem, nil)
+	} else {
+		env := web.Envelope{"error": message}
+		if id := app.contextGetRequestID(r); id != "" {
+			env["request_id"] = id
+		}
+		err = web.WriteJSON(w, status, env, nil)
+	}
 
-	err := web.WriteJSON(w, status, envelope, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// newProblem creates the problem details of an error response. The validation
+// errors are listed as invalid params.
+func newProblem(r *http.Request, status int, code string, message any) web.Problem {
+	problem := web.Problem{
+		Type:     problemTypeBase + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.RequestURI(),
+		Code:     code,
+	}
+
+	if status == statusClientClosedRequest {
+		problem.Title = "Client Closed Request"
+	}
+
+	switch m := message.(type) {
+	case string:
+		problem.Detail = m
+	case map[string]string:
+		problem.Detail = "one or more parameters are invalid"
+		problem.InvalidParams = web.NewInvalidParams(m)
+	}
+
+	return problem
+}
+
 // serverErrorResponse method will be used when our application encounters an
 // unexpected problem at runtime to send a 500 Internal Server Error.
+// Queries which were canceled or ran out of time are not unexpected, so they
+// are answered with a 499 Client Closed Request or a 503 Service Unavailable.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrQueryCanceled):
+		app.clientClosedRequestResponse(w, r)
+		return
+	case errors.Is(err, data.ErrQueryTimeout):
+		app.logError(r, err)
+		app.serviceUnavailableResponse(w, r)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, message)
+}
+
+// clientClosedRequestResponse method will be used to send a 499 Client Closed Request
+// when the client has gone away while its request was being processed.
+func (app *application) clientClosedRequestResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request was canceled by the client"
+	app.errorResponse(w, r, statusClientClosedRequest, codeClientClosedRequest, message)
+}
+
+// serviceUnavailableResponse method will be used to send a 503 Service Unavailable
+// when the database didn't answer within the time budget of a query.
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the server is temporarily unable to process your request, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, codeServiceUnavailable, message)
 }
 
 // badRequestResponse method will be used to send a 400 Bad Request.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
 }
 
 // notFoundResponse method will be used to send a 404 Not Found.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, message)
 }
 
 // methodNotAllowedResponse method will be used to send a 405 Method Not Allowed.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, message)
 }
 
 // failedValidationResponse method will be used to send a 422 Unprocessable Entity.
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeFailedValidation, errors)
 }
 
 // editConflictResponse method will be used to send a 409 Conflict.
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, message)
 }
 
 // rateLimitExceededResponse method will be used to send a 429 To Many Requests.
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimitExceeded, message)
+}
+
+// invalidCredentialsResponse method will be used to send a 401 Unauthorized
+// when the provided email and password don't match.
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials, message)
+}
+
+// invalidAuthenticationTokenResponse method will be used to send a 401 Unauthorized
+// when the bearer token is missing, malformed, expired or unknown.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidAuthenticationToken, message)
+}
+
+// authenticationRequiredResponse method will be used to send a 401 Unauthorized
+// when an anonymous user tries to access a protected endpoint.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthenticationRequired, message)
+}
+
+// inactiveAccountResponse method will be used to send a 403 Forbidden
+// when a user that is not activated tries to access a protected endpoint.
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, message)
+}
+
+// notPermittedResponse method will be used to send a 403 Forbidden
+// when a user doesn't have the permission needed to access an endpoint.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message)
+}
+
+// preconditionFailedResponse method will be used to send a 412 Precondition Failed
+// when the If-Match header doesn't match the current version of the resource.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since it was fetched, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, codePreconditionFailed, message)
+}
+
+// preconditionRequiredResponse method will be used to send a 428 Precondition Required
+// when a request that modifies a resource doesn't have an If-Match header.
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must be conditional, please provide an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, codePreconditionRequired, message)
+}
+
+// unsupportedMediaTypeResponse method will be used to send a 415 Unsupported Media Type
+// when the body of the request is not in one of the supported media types.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the body must be in one of the media types: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, message)
+}
+
+// notAcceptableResponse method will be used to send a 406 Not Acceptable
+// when the response can't be in any of the media types accepted by the client.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the response can only be in one of the media types: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, codeNotAcceptable, message)
+}
+
+// requestTooLargeResponse method will be used to send a 413 Request Entity Too Large
+// when the body of the request is larger than limit bytes.
+func (app *application) requestTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	message := fmt.Sprintf("the body must not be larger than %d bytes", limit)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, codeRequestTooLarge, message)
+}
+
+// patchTestFailedResponse method will be used to send a 409 Conflict
+// when a "test" operation of a JSON Patch doesn't match the resource.
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, codePatchTestFailed, err.Error())
+}
+
+// idempotencyKeyReusedResponse method will be used to send a 422 Unprocessable Entity
+// when an Idempotency-Key header is reused for a different request.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the idempotency key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, message)
+}
+
+// idempotencyKeyInFlightResponse method will be used to send a 409 Conflict
+// when the first request with an Idempotency-Key header is still being processed.
+func (app *application) idempotencyKeyInFlightResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with the same idempotency key is being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, codeIdempotencyKeyInFlight, message)
 }
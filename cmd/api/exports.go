@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// exportFlushRows is the number of movies written between two flushes of the response.
+const exportFlushRows = 500
+
+// exportChunkTimeout is the time budget for writing a chunk of exportFlushRows movies.
+// The write deadline of the connection is extended by it for every chunk, so the
+// exports taking longer than the WriteTimeout of the server aren't cut off.
+const exportChunkTimeout = 30 * time.Second
+
+type (
+	// movieExportWriter writes the movies of an export in one of the export formats.
+	movieExportWriter interface {
+		begin() error
+		write(movie *data.Movie) error
+		// flush writes the buffered movies, if the writer buffers them.
+		flush() error
+		end() error
+	}
+
+	// ndjsonExportWriter writes every movie as a JSON object on its own line.
+	ndjsonExportWriter struct {
+		w io.Writer
+	}
+
+	// jsonExportWriter writes the movies as the elements of a JSON array.
+	jsonExportWriter struct {
+		w     io.Writer
+		count int
+	}
+
+	// csvExportWriter writes every movie as a CSV row, after a header row.
+	csvExportWriter struct {
+		w *csv.Writer
+	}
+)
+
+// exportMoviesHandler for the "GET /v1/movies/export?..." endpoint.
+// It streams every movie matching the same filters as readAllMoviesHandler, up to the
+// configured number of rows, as NDJSON, CSV or a JSON array depending on the Accept header.
+// The clients accepting none of them get a 406 Not Acceptable.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var search data.MovieSearch
+
+	qs := r.URL.Query()
+	search.Title = web.ReadString(qs, "title", "")
+	search.Genres = web.ReadCSV(qs, "genres", []string{})
+	search.Person = web.ReadString(qs, "person", "")
+	search.Director = web.ReadString(qs, "director", "")
+
+	var (
+		writer      movieExportWriter
+		contentType string
+		filename    string
+	)
+	switch {
+	case web.Accepts(r, "application/x-ndjson"):
+		writer, contentType, filename = &ndjsonExportWriter{w: w}, "application/x-ndjson", "movies.ndjson"
+	case web.Accepts(r, "text/csv"):
+		writer, contentType, filename = &csvExportWriter{w: csv.NewWriter(w)}, "text/csv", "movies.csv"
+	case web.AcceptsAny(r, "application/json"):
+		writer, contentType, filename = &jsonExportWriter{w: w}, "application/json", "movies.json"
+	default:
+		app.notAcceptableResponse(w, r, "application/json", "application/x-ndjson", "text/csv")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// extendDeadline gives the next chunk of movies its own time budget.
+	extendDeadline := func() error {
+		err := rc.SetWriteDeadline(time.Now().Add(exportChunkTimeout))
+		if errors.Is(err, http.ErrNotSupported) {
+			return nil
+		}
+		return err
+	}
+
+	// The response starts with the first movie, so the errors occurring before
+	// can still be answered with an error response.
+	written := 0
+	start := func() error {
+		err := extendDeadline()
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.WriteHeader(http.StatusOK)
+		return writer.begin()
+	}
+
+	err := app.repositories.Movies.ReadEach(r.Context(), search, app.config.export.maxRows, func(movie *data.Movie) error {
+		if written == 0 {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := writer.write(movie)
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushRows == 0 {
+			err := writer.flush()
+			if err != nil {
+				return err
+			}
+
+			err = rc.Flush()
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+
+			err = extendDeadline()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		if written == 0 {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// The response is already on its way, so it is left truncated.
+		if !errors.Is(err, data.ErrQueryCanceled) {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	if written == 0 {
+		err = start()
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+
+	err = writer.end()
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (ew *ndjsonExportWriter) begin() error {
+	return nil
+}
+
+func (ew *ndjsonExportWriter) write(movie *data.Movie) error {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	_, err = ew.w.Write(append(js, '\n'))
+	return err
+}
+
+func (ew *ndjsonExportWriter) flush() error {
+	return nil
+}
+
+func (ew *ndjsonExportWriter) end() error {
+	return nil
+}
+
+func (ew *jsonExportWriter) begin() error {
+	_, err := io.WriteString(ew.w, "[")
+	return err
+}
+
+func (ew *jsonExportWriter) write(movie *data.Movie) error {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n"
+	if ew.count == 0 {
+		separator = "\n"
+	}
+	ew.count++
+
+	_, err = ew.w.Write(append([]byte(separator), js...))
+	return err
+}
+
+func (ew *jsonExportWriter) flush() error {
+	return nil
+}
+
+func (ew *jsonExportWriter) end() error {
+	_, err := io.WriteString(ew.w, "\n]\n")
+	return err
+}
+
+func (ew *csvExportWriter) begin() error {
+	return ew.w.Write([]string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "review_count"})
+}
+
+func (ew *csvExportWriter) write(movie *data.Movie) error {
+	return ew.w.Write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, ","),
+		strconv.FormatInt(int64(movie.Version), 10),
+		strconv.FormatFloat(movie.AverageRating, 'f', -1, 64),
+		strconv.FormatInt(int64(movie.ReviewCount), 10),
+	})
+}
+
+func (ew *csvExportWriter) flush() error {
+	ew.w.Flush()
+	return ew.w.Error()
+}
+
+func (ew *csvExportWriter) end() error {
+	return ew.flush()
+}
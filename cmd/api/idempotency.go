@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// maxIdempotencyKeyLength limits the length of the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// completeIdempotencyKeyAttempts is the number of attempts to store the response of a
+// request, before its key is left in flight until its lease ends.
+const completeIdempotencyKeyAttempts = 3
+
+// idempotencyRecorder passes the response through to the client while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes the requests with an Idempotency-Key header safe to retry. The response
+// of the first request using a key on the route is stored until the key expires, and is
+// replayed to the retries of the same request. The key can't be reused for a different
+// request (422), nor while the first request is in flight (409). The responses with a
+// 5xx status are not stored, so those requests can be retried with the same key. A key
+// whose request never completed is taken over by a retry once its lease ends.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if header == "" {
+			next(w, r)
+			return
+		}
+
+		if len(header) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("Idempotency-Key header must not be more than %d bytes long", maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := web.ReadBody(w, r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := data.IdempotencyKey{
+			Key:         header,
+			Route:       r.Method + " " + r.URL.Path,
+			ExpiresAt:   time.Now().Add(app.config.idempotency.ttl),
+			LockedUntil: time.Now().Add(app.config.idempotency.lease),
+			Fingerprint: requestFingerprint(r, app.contextGetUser(r).ID, body),
+		}
+
+		fingerprint := key.Fingerprint
+		claimed, err := app.repositories.IdempotencyKeys.Claim(r.Context(), &key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case !bytes.Equal(key.Fingerprint, fingerprint):
+				app.idempotencyKeyReusedResponse(w, r)
+			case key.Status == 0:
+				app.idempotencyKeyInFlightResponse(w, r)
+			default:
+				// The ID of the retry is kept, rather than the one of the first request.
+				id := w.Header().Get("X-Request-ID")
+				for name, values := range key.Header {
+					w.Header()[name] = values
+				}
+				if id != "" {
+					w.Header().Set("X-Request-ID", id)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(key.Status)
+				w.Write(key.Body)
+			}
+			return
+		}
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.releaseIdempotencyKey(r, &key)
+				panic(err)
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			app.releaseIdempotencyKey(r, &key)
+			return
+		}
+
+		key.Status = rec.status
+		key.Header = w.Header().Clone()
+		key.Body = rec.body.Bytes()
+
+		// The response is stored even if the client is gone, since the request succeeded.
+		// It's retried, since the retries of the request would otherwise get a conflict
+		// until the lease of the key ends, and then run the request again.
+		for attempt := 1; ; attempt++ {
+			err = app.repositories.IdempotencyKeys.Complete(context.Background(), &key)
+			if err == nil {
+				break
+			}
+			if attempt == completeIdempotencyKeyAttempts {
+				app.logError(r, err)
+				break
+			}
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+}
+
+// releaseIdempotencyKey releases the key of a request which failed.
+func (app *application) releaseIdempotencyKey(r *http.Request, key *data.IdempotencyKey) {
+	err := app.repositories.IdempotencyKeys.Release(context.Background(), key)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// requestFingerprint returns a hash of the request, made of its method, URL,
+// user and body, which identifies the retries of the request.
+func requestFingerprint(r *http.Request, userID int64, body []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write([]byte(strconv.FormatInt(userID, 10) + "\n"))
+	hash.Write(body)
+	return hash.Sum(nil)
+}
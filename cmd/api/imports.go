@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// Formats of the movie imports.
+const (
+	importFormatCSV    = "csv"
+	importFormatNDJSON = "ndjson"
+)
+
+// maxImportLineBytes limits the length of a single NDJSON line.
+const maxImportLineBytes = 64 * 1024
+
+type (
+	// movieRowReader reads the movies of an import, one row at a time.
+	movieRowReader interface {
+		// next returns the line of the next row and the movie read from it. The error
+		// is a rowError when only the row is invalid, and io.EOF after the last row.
+		next() (int, data.NewMovie, error)
+	}
+
+	// rowError reports a row which can't be read, so it is rejected.
+	rowError struct {
+		errors map[string]string
+	}
+
+	// importBodyError reports a body which can't be imported at all,
+	// as opposed to the errors of single rows which are reported per row.
+	importBodyError struct {
+		err error
+	}
+)
+
+func (e rowError) Error() string {
+	return fmt.Sprintf("invalid row: %v", e.errors)
+}
+
+func (e importBodyError) Error() string {
+	return e.err.Error()
+}
+
+func (e importBodyError) Unwrap() error {
+	return e.err
+}
+
+// importMoviesHandler for the "POST /v1/movies/import" endpoint.
+// Small imports are processed right away and answered with their report. The imports
+// larger than the configured threshold (or of unknown length) are processed by a
+// background job and answered with the location of the job.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := importFormat(r.Header.Get("Content-Type"))
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	if r.ContentLength >= 0 && r.ContentLength <= app.config.imports.asyncBytes {
+		report, err := app.importMovies(r.Context(), body, format, app.contextGetUser(r).ID)
+		if err != nil {
+			app.importErrorResponse(w, r, err)
+			return
+		}
+
+		err = web.WriteJSON(w, http.StatusOK, web.Envelope{"report": report}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The body is spooled to a temporary file, since the job outlives the request.
+	file, err := os.CreateTemp("", "movies-import-*")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = io.Copy(file, body)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		app.importErrorResponse(w, r, importBodyError{err})
+		return
+	}
+
+	job := data.ImportJob{
+		UserID:      app.contextGetUser(r).ID,
+		Format:      format,
+		Owner:       app.instanceID,
+		LockedUntil: time.Now().Add(app.config.imports.lease),
+	}
+
+	err = app.repositories.ImportJobs.Create(r.Context(), &job)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r.Context(), func(ctx context.Context) {
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		app.runImportJob(ctx, &job, file)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/imports/%d", job.ID))
+
+	err = web.WriteJSON(w, http.StatusAccepted, web.Envelope{"import": job}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readImportJobHandler for the "GET /v1/imports/:id" endpoint.
+// Only the user who started the import can read it.
+func (app *application) readImportJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.repositories.ImportJobs.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if job.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"import": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importErrorResponse sends the error response of an import which failed as a whole.
+func (app *application) importErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		maxBytesError *http.MaxBytesError
+		bodyError     importBodyError
+	)
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		app.requestTooLargeResponse(w, r, maxBytesError.Limit)
+	case errors.As(err, &bodyError):
+		app.badRequestResponse(w, r, err)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runImportJob processes the import of a background job and records its outcome.
+// ctx isn't cancelled with the request which started the job, and a graceful
+// shutdown waits for it. The lease on the job is renewed while it runs; the import
+// is cancelled if the job was failed as interrupted in the meantime.
+func (app *application) runImportJob(ctx context.Context, job *data.ImportJob, src io.Reader) {
+	log := app.logger.FromContext(ctx).With(map[string]string{"import_id": strconv.FormatInt(job.ID, 10)})
+
+	// A panic must not leave the job running until its lease expires.
+	defer func() {
+		if err := recover(); err != nil {
+			log.PrintError(fmt.Errorf("%s", err), nil)
+
+			job.Status = data.ImportStatusFailed
+			job.Error = "the import failed unexpectedly"
+			job.ImportReport = data.ImportReport{}
+
+			updateErr := app.repositories.ImportJobs.Update(ctx, job)
+			if updateErr != nil {
+				log.PrintError(updateErr, nil)
+			}
+		}
+	}()
+
+	importCtx, cancelImport := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		app.renewImportJobLease(importCtx, cancelImport, job.ID)
+	}()
+	defer func() {
+		cancelImport()
+		<-renewed
+	}()
+
+	err := app.repositories.ImportJobs.Start(ctx, job)
+	if err != nil {
+		log.PrintError(err, nil)
+		return
+	}
+
+	report, err := app.importMovies(importCtx, src, job.Format, job.UserID)
+	if err != nil {
+		job.Status = data.ImportStatusFailed
+		job.Error = err.Error()
+
+		var bodyError importBodyError
+		if !errors.As(err, &bodyError) {
+			log.PrintError(err, nil)
+		}
+	} else {
+		job.Status = data.ImportStatusCompleted
+		job.ImportReport = report
+	}
+
+	err = app.repositories.ImportJobs.Update(ctx, job)
+	if err != nil {
+		log.PrintError(err, nil)
+	}
+}
+
+// renewImportJobLease extends the lease of this process on the import job, three
+// times per lease, until ctx is done. It calls cancel when the job is no longer
+// owned by this process, because it was failed as interrupted.
+func (app *application) renewImportJobLease(ctx context.Context, cancel context.CancelFunc, id int64) {
+	ticker := time.NewTicker(app.config.imports.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.repositories.ImportJobs.Renew(ctx, id, app.instanceID, time.Now().Add(app.config.imports.lease))
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			cancel()
+			return
+		case err != nil && ctx.Err() == nil:
+			app.logger.PrintError(err, map[string]string{"import_id": strconv.FormatInt(id, 10)})
+		}
+	}
+}
+
+// startInterruptedImportsWorker periodically fails the import jobs whose lease expired.
+// The jobs only run in the process which accepted them, and a graceful shutdown waits
+// for them, so those jobs were interrupted by a crash and their uploads are lost.
+func (app *application) startInterruptedImportsWorker(ctx context.Context) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.imports.lease)
+		defer ticker.Stop()
+
+		for {
+			app.failInterruptedImportJobs(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// failInterruptedImportJobs marks the import jobs whose lease expired as failed.
+func (app *application) failInterruptedImportJobs(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	failed, err := app.repositories.ImportJobs.FailInterrupted(ctx, "the import was interrupted by a crash of the server running it")
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if failed > 0 {
+		app.logger.PrintInfo("failed interrupted imports", map[string]string{
+			"imports": strconv.FormatInt(failed, 10),
+		})
+	}
+}
+
+// importMovies reads the movies from src, runs them through Movie.Validate and inserts
+// the valid ones in batches, along with their revisions made by the user. The batches are
+// inserted in a single transaction, so either every valid movie is imported or none is;
+// on error the report is empty.
+func (app *application) importMovies(ctx context.Context, src io.Reader, format string, userID int64) (data.ImportReport, error) {
+	report := data.ImportReport{Rows: []data.ImportRow{}}
+
+	reader, err := newMovieRowReader(src, format)
+	if err != nil {
+		return data.ImportReport{}, importBodyError{err}
+	}
+
+	reject := func(line int, errors map[string]string) {
+		report.Rejected++
+		report.Rows = append(report.Rows, data.ImportRow{Line: line, Status: data.ImportRowRejected, Errors: errors})
+	}
+
+	err = app.repositories.Transaction(ctx, func(tx data.Repositories) error {
+		batch := make([]*data.Movie, 0, app.config.imports.batchSize)
+
+		insert := func() error {
+			err := tx.Movies.CreateMany(ctx, batch)
+			if err != nil {
+				return err
+			}
+
+			revisions := make([]*data.MovieRevision, len(batch))
+			for i, movie := range batch {
+				revisions[i] = data.NewMovieRevision(data.RevisionCreate, movie, userID)
+			}
+			return tx.MovieRevisions.CreateMany(ctx, revisions)
+		}
+
+		for {
+			line, input, err := reader.next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			var rowErr rowError
+			switch {
+			case errors.As(err, &rowErr):
+				reject(line, rowErr.errors)
+				continue
+			case err != nil:
+				return importBodyError{err}
+			}
+
+			var movie data.Movie
+			movie.FromNewMovie(input)
+
+			vld := validator.New()
+			if movie.Validate(vld); !vld.Valid() {
+				reject(line, vld.Errors)
+				continue
+			}
+
+			report.Accepted++
+			report.Rows = append(report.Rows, data.ImportRow{Line: line, Status: data.ImportRowAccepted})
+
+			batch = append(batch, &movie)
+			if len(batch) == cap(batch) {
+				err := insert()
+				if err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+		return insert()
+	})
+	if err != nil {
+		return data.ImportReport{}, err
+	}
+
+	return report, nil
+}
+
+// importFormat returns the import format of the content type.
+func importFormat(contentType string) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	switch mediaType {
+	case "text/csv":
+		return importFormatCSV, true
+	case "application/x-ndjson":
+		return importFormatNDJSON, true
+	default:
+		return "", false
+	}
+}
+
+// newMovieRowReader creates the reader of the movies of src in the given format.
+func newMovieRowReader(src io.Reader, format string) (movieRowReader, error) {
+	switch format {
+	case importFormatCSV:
+		return newCSVMovieReader(src)
+	case importFormatNDJSON:
+		scanner := bufio.NewScanner(src)
+		scanner.Buffer(make([]byte, 0, 4096), maxImportLineBytes)
+		return &ndjsonMovieReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+}
+
+// csvMovieReader reads movies from CSV rows. The first row is a header naming the
+// title, year, runtime and genres columns, in any order. The runtime is either a number
+// of minutes or "<runtime> mins", and the genres are separated by commas.
+type csvMovieReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVMovieReader(src io.Reader) (*csvMovieReader, error) {
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, "title", "year", "runtime", "genres") {
+			return nil, fmt.Errorf("csv header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	if len(columns) != 4 {
+		return nil, errors.New("csv header must contain the title, year, runtime and genres columns")
+	}
+
+	return &csvMovieReader{reader: reader, columns: columns}, nil
+}
+
+func (cr *csvMovieReader) next() (int, data.NewMovie, error) {
+	var input data.NewMovie
+
+	record, err := cr.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return parseError.StartLine, input, rowError{map[string]string{"row": parseError.Err.Error()}}
+		}
+		return 0, input, err
+	}
+
+	line, _ := cr.reader.FieldPos(0)
+	if len(record) != len(cr.columns) {
+		return line, input, rowError{map[string]string{"row": fmt.Sprintf("must have %d fields", len(cr.columns))}}
+	}
+
+	field := func(name string) string {
+		return strings.TrimSpace(record[cr.columns[name]])
+	}
+
+	errs := make(map[string]string)
+
+	input.Title = field("title")
+
+	if year := field("year"); year != "" {
+		value, err := strconv.ParseInt(year, 10, 32)
+		if err != nil {
+			errs["year"] = "must be an integer"
+		}
+		input.Year = int32(value)
+	}
+
+	if runtime := field("runtime"); runtime != "" {
+		value, err := strconv.ParseInt(runtime, 10, 32)
+		if err != nil {
+			err = input.Runtime.UnmarshalJSON([]byte(strconv.Quote(runtime)))
+			if err != nil {
+				errs["runtime"] = "must be a number of minutes or in the format \"<runtime> mins\""
+			}
+		} else {
+			input.Runtime = data.Runtime(value)
+		}
+	}
+
+	if genres := field("genres"); genres != "" {
+		for _, genre := range strings.Split(genres, ",") {
+			input.Genres = append(input.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	if len(errs) > 0 {
+		return line, input, rowError{errs}
+	}
+
+	return line, input, nil
+}
+
+// ndjsonMovieReader reads movies from lines holding a JSON object each,
+// in the same format as the body of "POST /v1/movies". Blank lines are skipped.
+type ndjsonMovieReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (nr *ndjsonMovieReader) next() (int, data.NewMovie, error) {
+	var input data.NewMovie
+
+	for nr.scanner.Scan() {
+		nr.line++
+
+		text := bytes.TrimSpace(nr.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("must only contain a single JSON value")
+		}
+		if err != nil {
+			return nr.line, data.NewMovie{}, rowError{map[string]string{"row": err.Error()}}
+		}
+
+		return nr.line, input, nil
+	}
+
+	if err := nr.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return 0, input, fmt.Errorf("line %d is longer than %d bytes", nr.line+1, maxImportLineBytes)
+		}
+		return 0, input, err
+	}
+
+	return 0, input, io.EOF
+}
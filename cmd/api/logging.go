@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mroobert/json-api/internal/logger"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// Formats of the log entries.
+const (
+	logFormatJSON = "json"
+	logFormatText = "text" // Human-friendly lines, for development
+)
+
+// configureLogger applies the log settings of the configuration to the logger.
+func configureLogger(l *logger.Logger, cfg config) error {
+	level, err := logger.ParseLevel(cfg.log.level)
+	if err != nil {
+		return err
+	}
+
+	var formatter logger.Formatter
+	switch cfg.log.format {
+	case logFormatJSON:
+		formatter = logger.JSONFormatter{}
+	case logFormatText:
+		formatter = logger.TextFormatter{}
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.log.format)
+	}
+
+	var traces []logger.Level
+	for _, name := range strings.Split(cfg.log.stackTraces, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		level, err := logger.ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		traces = append(traces, level)
+	}
+
+	l.SetLevel(level)
+	l.Configure(logger.WithFormatter(formatter), logger.WithStackTraces(traces...))
+
+	return nil
+}
+
+// readLogLevelHandler for the "GET /log/level" endpoint of the admin server.
+func (app *application) readLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := web.WriteJSON(w, http.StatusOK, web.Envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler for the "PUT /log/level" endpoint of the admin server.
+// The level is changed at runtime, for the whole application.
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+
+	level, err := logger.ParseLevel(input.Level)
+	vld.Check(err == nil, "level", "must be one of debug, info, warn, error, fatal or off")
+
+	if !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	// Logged at the WARN level, so it isn't filtered out by most levels.
+	app.logger.Warn("log level changed",
+		logger.String("from", previous.String()),
+		logger.String("to", level.String()),
+	)
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"flag"
 	"fmt"
+	"math"
 	"os"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/mroobert/json-api/internal/data"
 	"github.com/mroobert/json-api/internal/database"
 	"github.com/mroobert/json-api/internal/logger"
 	"github.com/mroobert/json-api/internal/mailer"
+	"github.com/mroobert/json-api/migrations"
 )
 
 // version contains the application version number.
@@ -19,14 +24,61 @@ const version = "1.0.0"
 // We will read in these configuration settings from command-line
 // flags when the application starts.
 type config struct {
-	db      database.Config
-	env     string
+	accessLog struct {
+		format    string
+		sample2xx float64
+		skip      []string
+	}
+	cursor struct {
+		secret []byte
+	}
+	db     database.Config
+	env    string
+	export struct {
+		maxRows int
+	}
+	idempotency struct {
+		lease time.Duration
+		ttl   time.Duration
+	}
+	imports struct {
+		batchSize  int
+		asyncBytes int64
+		maxBytes   int64
+		lease      time.Duration
+	}
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
 	}
-	port int
+	log struct {
+		format      string
+		level       string
+		stackTraces string
+	}
+	metrics struct {
+		host string
+		port int
+	}
+	movies struct {
+		createOnPut bool
+		maxPutID    int64
+	}
+	outbox struct {
+		pollInterval time.Duration
+		batchSize    int
+		maxAttempts  int
+		backoff      time.Duration
+	}
+	port          int
+	preconditions struct {
+		required bool
+	}
+	purge struct {
+		interval  time.Duration
+		retention time.Duration
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -43,7 +95,9 @@ type application struct {
 	logger       *logger.Logger
 	repositories data.Repositories
 	mailer       mailer.Mailer
-	wg           sync.WaitGroup
+	metrics      *appMetrics
+	wg           taskGroup // Background goroutines, waited for on shutdown
+	instanceID   string    // Identifies this process among the replicas, e.g. as the owner of the import jobs
 }
 
 func main() {
@@ -66,6 +120,38 @@ func run(logger *logger.Logger) error {
 	flag.IntVar(&cfg.db.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.MinConns, "db-min-conns", 25, "PostgreSQL mininum size pool")
 	flag.StringVar(&cfg.db.MaxConnIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.QueryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL time budget of a single query")
+	flag.BoolVar(&cfg.db.MigrateOnStart, "db-migrate-on-start", false, "Apply the pending migrations before starting the server")
+
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum level of the log entries (debug|info|warn|error|fatal|off)")
+	flag.StringVar(&cfg.log.format, "log-format", logFormatJSON, "Log format (json|text)")
+	flag.StringVar(&cfg.log.stackTraces, "log-stack-traces", "error,fatal", "Comma-separated levels whose log entries include a stack trace")
+
+	var accessLogSkip string
+	flag.StringVar(&cfg.accessLog.format, "access-log-format", accessLogJSON, "Access log format (json|combined|off)")
+	flag.Float64Var(&cfg.accessLog.sample2xx, "access-log-sample-2xx", 1, "Fraction of the 2xx responses written to the access log")
+	flag.StringVar(&accessLogSkip, "access-log-skip", "/v1/healthcheck", "Comma-separated paths left out of the access log")
+
+	flag.BoolVar(&cfg.preconditions.required, "preconditions-required", false, "Require an If-Match header to update or delete a movie")
+
+	flag.StringVar(&cfg.metrics.host, "metrics-host", "127.0.0.1", "Admin server host (it has no authentication, so expose it only on a private interface)")
+	flag.IntVar(&cfg.metrics.port, "metrics-port", 4001, "Admin server port exposing the metrics and the log level (0 to disable)")
+
+	flag.BoolVar(&cfg.movies.createOnPut, "movies-create-on-put", false, "Create the missing movies on PUT, with the IDs chosen by the clients")
+	flag.Int64Var(&cfg.movies.maxPutID, "movies-max-put-id", math.MaxInt32, "Highest movie ID the clients can choose on PUT, so that the ID sequence isn't exhausted")
+
+	flag.IntVar(&cfg.export.maxRows, "export-max-rows", 100_000, "Movie export maximum number of rows")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "Time an idempotency key and its response are kept")
+	flag.DurationVar(&cfg.idempotency.lease, "idempotency-lease", time.Minute, "Time after which a retry takes over an idempotency key whose first request never completed")
+
+	flag.IntVar(&cfg.imports.batchSize, "import-batch-size", 1000, "Movie import rows inserted per COPY")
+	flag.Int64Var(&cfg.imports.asyncBytes, "import-async-bytes", 1<<20, "Movie imports larger than this size (in bytes) run as background jobs")
+	flag.Int64Var(&cfg.imports.maxBytes, "import-max-bytes", 100<<20, "Movie import maximum size (in bytes)")
+	flag.DurationVar(&cfg.imports.lease, "import-lease", time.Minute, "Time after which a background import whose server stopped renewing it is failed as interrupted")
+
+	flag.DurationVar(&cfg.purge.interval, "purge-interval", time.Hour, "Interval between two purges of the deleted movies")
+	flag.DurationVar(&cfg.purge.retention, "purge-retention", 30*24*time.Hour, "Time a deleted movie is kept before being purged")
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
@@ -77,8 +163,54 @@ func run(logger *logger.Logger) error {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "d22607e75cecd5", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "JSON-API <no-reply@jsonapi.mroobert.net>", "SMTP sender")
 
+	flag.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "Email outbox polling interval")
+	flag.IntVar(&cfg.outbox.batchSize, "outbox-batch-size", 10, "Email outbox maximum emails sent per poll")
+	flag.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 5, "Email outbox attempts before an email is dead-lettered")
+	flag.DurationVar(&cfg.outbox.backoff, "outbox-backoff", 30*time.Second, "Email outbox initial retry backoff (doubled after each attempt)")
+
+	var cursorSecret string
+	flag.StringVar(&cursorSecret, "cursor-secret", os.Getenv("CURSOR_SECRET"), "Secret used to sign the pagination cursors")
+
 	flag.Parse()
 
+	err := configureLogger(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.accessLog.format {
+	case accessLogJSON, accessLogCombined, accessLogOff:
+	default:
+		return fmt.Errorf("unknown access log format %q", cfg.accessLog.format)
+	}
+	if cfg.accessLog.sample2xx < 0 || cfg.accessLog.sample2xx > 1 {
+		return fmt.Errorf("access log sample of the 2xx responses must be between 0 and 1")
+	}
+	for _, path := range strings.Split(accessLogSkip, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			cfg.accessLog.skip = append(cfg.accessLog.skip, path)
+		}
+	}
+
+	if cfg.imports.lease <= 0 {
+		return fmt.Errorf("import lease must be positive")
+	}
+
+	cfg.cursor.secret = []byte(cursorSecret)
+	if len(cfg.cursor.secret) == 0 {
+		// Without a configured secret the cursors are only valid for the
+		// lifetime of this process.
+		cfg.cursor.secret = make([]byte, 32)
+		_, err := rand.Read(cfg.cursor.secret)
+		if err != nil {
+			return fmt.Errorf("error generating cursor secret: %v", err)
+		}
+		logger.PrintInfo("no cursor secret configured, using a random one", nil)
+	}
+
+	// The queries are logged through the logger, with the ID of their request.
+	cfg.db.QueryLogger = logger.Slog()
+
 	db, err := database.OpenConnection(cfg.db)
 	if err != nil {
 		return fmt.Errorf("error opening database: %v", err)
@@ -86,10 +218,33 @@ func run(logger *logger.Logger) error {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Commands given after the flags run instead of the server.
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			return runMigrate(logger, db, args[1:])
+		default:
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+	}
+
+	if cfg.db.MigrateOnStart {
+		migrator, err := database.NewMigrator(db, migrations.FS)
+		if err != nil {
+			return err
+		}
+
+		applied, err := migrator.Up(context.Background())
+		logMigrations(logger, "applied migration", applied)
+		if err != nil {
+			return fmt.Errorf("error migrating database: %v", err)
+		}
+	}
+
 	app := &application{
 		config:       cfg,
 		logger:       logger,
-		repositories: data.NewRepositories(db),
+		repositories: data.NewRepositories(db, cfg.db.QueryTimeout),
 		mailer: mailer.New(
 			cfg.smtp.host,
 			cfg.smtp.port,
@@ -99,6 +254,13 @@ func run(logger *logger.Logger) error {
 		),
 	}
 
+	app.metrics = newAppMetrics(db, &app.wg)
+
+	app.instanceID, err = newInstanceID()
+	if err != nil {
+		return fmt.Errorf("error generating instance ID: %v", err)
+	}
+
 	// Start http server.
 	err = app.serve()
 	if err != nil {
@@ -107,3 +269,20 @@ func run(logger *logger.Logger) error {
 
 	return err
 }
+
+// newInstanceID returns the host name followed by a random suffix, so that
+// the processes running on the same host have distinct IDs.
+func newInstanceID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%x", host, suffix), nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mroobert/json-api/internal/metrics"
+)
+
+// unmatchedRoute labels the requests which don't match any route, as well as the
+// requests answered before routing (e.g. by the rate limiter or the authentication).
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels the requests with a non-standard method, so the clients
+// can't create an unbounded number of series.
+const otherMethod = "other"
+
+type (
+	// appMetrics holds the metrics of the application.
+	appMetrics struct {
+		registry        *metrics.Registry
+		requests        *metrics.Counter
+		requestDuration *metrics.Histogram
+		inFlight        *metrics.Gauge
+		rateLimited     *metrics.Counter
+		emails          *metrics.Counter
+	}
+
+	// taskGroup is a sync.WaitGroup which also counts its running goroutines.
+	taskGroup struct {
+		sync.WaitGroup
+		running atomic.Int64
+	}
+)
+
+// newAppMetrics registers the metrics of the application, including the statistics
+// of the database pool and the number of background goroutines, read when exposed.
+func newAppMetrics(db *pgxpool.Pool, tasks *taskGroup) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.NewCounter("http_requests_total",
+			"Number of HTTP requests handled. The requests answered before routing, like the 401 and 429 responses, have the route \"unmatched\".",
+			"method", "route", "status_class"),
+		requestDuration: registry.NewHistogram("http_request_duration_seconds",
+			"Duration of the HTTP requests. The requests answered before routing, like the 401 and 429 responses, have the route \"unmatched\".",
+			metrics.DefaultBuckets, "method", "route", "status_class"),
+		inFlight: registry.NewGauge("http_requests_in_flight",
+			"Number of HTTP requests being handled."),
+		rateLimited: registry.NewCounter("rate_limiter_rejections_total",
+			"Number of HTTP requests rejected by the rate limiter."),
+		emails: registry.NewCounter("mailer_sends_total",
+			"Number of emails sent, by result.", "result"),
+	}
+
+	registry.NewGaugeFunc("background_goroutines",
+		"Number of running background goroutines.", func() float64 {
+			return float64(tasks.Running())
+		})
+
+	poolGauge := func(name, help string, fn func(*pgxpool.Stat) float64) {
+		registry.NewGaugeFunc(name, help, func() float64 { return fn(db.Stat()) })
+	}
+	poolCounter := func(name, help string, fn func(*pgxpool.Stat) float64) {
+		registry.NewCounterFunc(name, help, func() float64 { return fn(db.Stat()) })
+	}
+
+	poolGauge("db_pool_acquired_conns", "Number of connections currently acquired from the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) })
+	poolGauge("db_pool_idle_conns", "Number of idle connections in the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) })
+	poolGauge("db_pool_constructing_conns", "Number of connections being established.",
+		func(s *pgxpool.Stat) float64 { return float64(s.ConstructingConns()) })
+	poolGauge("db_pool_total_conns", "Number of connections in the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) })
+	poolGauge("db_pool_max_conns", "Maximum size of the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) })
+	poolCounter("db_pool_acquires_total", "Number of successful connection acquires.",
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) })
+	poolCounter("db_pool_empty_acquires_total", "Number of acquires which waited for a connection.",
+		func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) })
+	poolCounter("db_pool_canceled_acquires_total", "Number of acquires canceled by their context.",
+		func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) })
+	poolCounter("db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections.",
+		func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() })
+
+	return m
+}
+
+// recordMetrics counts the requests in flight and records the count and the duration
+// of the requests, labelled by their method, the pattern of their route and their status class.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		route := unmatchedRoute
+		r = r.WithContext(context.WithValue(r.Context(), routeContextKey, &route))
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		method := metricMethod(r.Method)
+		statusClass := strconv.Itoa(rec.Status()/100) + "xx"
+
+		app.metrics.requests.Inc(method, route, statusClass)
+		app.metrics.requestDuration.Observe(time.Since(start).Seconds(), method, route, statusClass)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// metricMethod returns the method label of a request: the standard methods
+// are kept and the others are labelled as otherMethod.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return otherMethod
+	}
+}
+
+// routePattern labels the request with the pattern of its route, for the metrics
+// and the access log.
+// The innermost pattern wins, so the handlers dispatched by staticSegments can
+// override the pattern of the ":id" route.
+func (app *application) routePattern(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+			*route = pattern
+		}
+
+		next(w, r)
+	}
+}
+
+// serveAdmin starts the admin server, which exposes the metrics on "GET /metrics" and
+// the level of the logs on "GET /log/level" and "PUT /log/level".
+// It has no authentication, so it listens on the loopback interface unless configured
+// otherwise. It returns the server, to shut it down along with the main server.
+func (app *application) serveAdmin() *http.Server {
+	router := httprouter.New()
+
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	router.Handler(http.MethodGet, "/metrics", app.metrics.registry)
+	router.HandlerFunc(http.MethodGet, "/log/level", app.readLogLevelHandler)
+	router.HandlerFunc(http.MethodPut, "/log/level", app.updateLogLevelHandler)
+
+	srv := &http.Server{
+		Addr:         net.JoinHostPort(app.config.metrics.host, strconv.Itoa(app.config.metrics.port)),
+		Handler:      router,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		app.logger.PrintInfo("starting admin server", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.PrintError(err, map[string]string{
+				"addr": srv.Addr,
+			})
+		}
+	}()
+
+	return srv
+}
+
+func (g *taskGroup) Add(delta int) {
+	g.running.Add(int64(delta))
+	g.WaitGroup.Add(delta)
+}
+
+func (g *taskGroup) Done() {
+	g.running.Add(-1)
+	g.WaitGroup.Done()
+}
+
+// Running returns the number of running goroutines.
+func (g *taskGroup) Running() int64 {
+	return g.running.Load()
+}
@@ -1,15 +1,79 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
 	"golang.org/x/time/rate"
 )
 
+// maxRequestIDLength limits the length of the X-Request-ID header accepted from the clients.
+const maxRequestIDLength = 128
+
+// requestID middleware identifies every request with the X-Request-ID header of the client,
+// or with a random ID if it's missing or invalid. The ID is echoed in the response, and is
+// stored in the request context along with a logger which adds it to the log entries.
+func (app *application) requestID(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// validRequestID reports whether the request ID is made of at most maxRequestIDLength
+// letters, digits and "-", "_", ".", ":" characters, which are safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID generates a random request ID of 32 hexadecimal characters.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 // recoverPanic middleware will recover a panic, log the error
 // and send the client a nice 500 Internal Server Error response with a JSON body.
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -85,6 +149,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				app.metrics.rateLimited.Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -96,3 +161,181 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(fn)
 }
+
+// authenticate resolves the "Authorization: Bearer <token>" header to a user
+// and stores it in the request context. Requests without the header are
+// handled as coming from the AnonymousUser.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// The response will vary depending on the value of the Authorization header,
+		// so caches must take it into account.
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		vld := validator.New()
+		if data.ValidateTokenPlaintext(vld, token); !vld.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.repositories.Users.ReadForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// requireAuthenticatedUser checks that the user is not anonymous.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedUser checks that the user is both authenticated and activated.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// requireSameUser checks that the user is activated and is the user identified by
+// the "id" URL parameter, so users can only reach their own resources.
+func (app *application) requireSameUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id, err := web.ReadIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		if id != app.contextGetUser(r).ID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
+
+// requirePermission checks that the user is activated and has the given permission code.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		permitted, err := app.hasPermission(r, code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permitted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
+
+// hasPermission reports whether the user of the request has the given permission code.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	permissions, err := app.repositories.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// responseRecorder records the status and the size of the response,
+// for the metrics and the access log.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+	return n, err
+}
+
+// Flush lets the streamed responses, such as the exports, go through the recorder.
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Status returns the status of the response, which is 200 if the handler didn't write it.
+func (rec *responseRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
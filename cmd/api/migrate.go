@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/logger"
+	"github.com/mroobert/json-api/migrations"
+)
+
+// migrateUsage describes the arguments of the migrate command.
+const migrateUsage = "usage: api [flags] migrate up | down [N] | status | goto V"
+
+// runMigrate executes the "migrate" command with the given arguments.
+func runMigrate(logger *logger.Logger, db *pgxpool.Pool, args []string) error {
+	migrator, err := database.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		logMigrations(logger, "applied migration", applied)
+		return err
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, n)
+		logMigrations(logger, "reverted migration", reverted)
+		return err
+
+	case "goto":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+
+		applied, reverted, err := migrator.Goto(ctx, version)
+		logMigrations(logger, "reverted migration", reverted)
+		logMigrations(logger, "applied migration", applied)
+		return err
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			status, appliedAt := "pending", ""
+			if s.Applied {
+				status, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			if s.Modified {
+				status += " (modified)"
+			}
+			if s.Missing {
+				status += " (missing file)"
+			}
+			fmt.Fprintf(tw, "%06d\t%s\t%s\t%s\n", s.Version, s.Name, status, appliedAt)
+		}
+		return tw.Flush()
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// logMigrations writes an INFO log entry for each of the migrations.
+func logMigrations(logger *logger.Logger, message string, migrations []database.Migration) {
+	for _, m := range migrations {
+		logger.PrintInfo(message, map[string]string{
+			"version": strconv.FormatInt(m.Version, 10),
+			"name":    m.Name,
+		})
+	}
+}
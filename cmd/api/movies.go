@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"reflect"
 
 	"github.com/mroobert/json-api/internal/data"
 	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/jsonpatch"
 	"github.com/mroobert/json-api/internal/validator"
 	"github.com/mroobert/json-api/internal/web"
 )
@@ -32,7 +37,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.repositories.Movies.Create(&movie)
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.Create(r.Context(), &movie)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionCreate, movie.ID)
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -40,6 +52,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movie.ETag())
 
 	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"movie": movie}, headers)
 	if err != nil {
@@ -55,7 +68,22 @@ func (app *application) readMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.repositories.Movies.Read(id)
+	vld := validator.New()
+	includeDeleted := web.ReadBool(r.URL.Query(), "include_deleted", false, vld)
+	if !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	read := app.repositories.Movies.Read
+	if includeDeleted {
+		if !app.checkIncludeDeleted(w, r) {
+			return
+		}
+		read = app.repositories.Movies.ReadIncludingDeleted
+	}
+
+	movie, err := read(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -67,14 +95,31 @@ func (app *application) readMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, nil)
+	// The client already has the current version of the movie.
+	if match := r.Header.Get("If-None-Match"); match != "" && web.ETagMatches(match, movie.ETag(), true) {
+		w.Header().Set("ETag", movie.ETag())
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	movie.Credits, err = app.repositories.Credits.ReadAllForMovie(r.Context(), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movie.ETag())
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
-// updateMovieHandler for the "PUT /v1/movies/:id" endpoint.
+// updateMovieHandler for the "PATCH /v1/movies/:id" endpoint.
+// The body is either an UpdateMovie, a JSON Merge Patch or a JSON Patch, see readMoviePatch.
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := web.ReadIDParam(r)
 	if err != nil || id < 1 {
@@ -82,7 +127,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.repositories.Movies.Read(id)
+	movie, err := app.repositories.Movies.Read(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -94,22 +139,116 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input data.UpdateMovie
+	if !app.checkIfMatch(w, r, movie) {
+		return
+	}
+
+	if !app.readMoviePatch(w, r, movie) {
+		return
+	}
+
+	vld := validator.New()
+	if movie.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionUpdate, movie.ID)
+	})
+	if err != nil {
+		switch {
+		// The movie changed since the If-Match header was evaluated.
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movie.ETag())
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// replaceMovieHandler for the "PUT /v1/movies/:id" endpoint.
+// The movie is replaced as a whole by the NewMovie of the body, which makes the request
+// idempotent: 200 OK when the movie changes, 204 No Content when it already matches the
+// body. When enabled in the config, a missing movie is created with the ID of the URL
+// (201 Created).
+func (app *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.repositories.Movies.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound) && app.config.movies.createOnPut:
+			app.createMovieOnPut(w, r, id)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if !app.checkIfMatch(w, r, movie) {
+		return
+	}
+
+	var input data.NewMovie
 	err = web.ReadJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+
+	// The movie already matches the body, so no new version is created.
+	if reflect.DeepEqual(movie.AsNewMovie(), input) {
+		w.Header().Set("ETag", movie.ETag())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	vld := validator.New()
-	movie.FromUpdateMovie(input)
+	movie.FromNewMovie(input)
 	if movie.Validate(vld); !vld.Valid() {
 		app.failedValidationResponse(w, r, vld.Errors)
 		return
 	}
 
-	err = app.repositories.Movies.Update(movie)
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionUpdate, movie.ID)
+	})
 	if err != nil {
 		switch {
+		// The movie changed since the If-Match header was evaluated.
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -119,14 +258,73 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", movie.ETag())
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMovieOnPut creates the movie of a PUT request whose ID is not taken yet.
+func (app *application) createMovieOnPut(w http.ResponseWriter, r *http.Request, id int64) {
+	// An If-Match header can't match a missing movie.
+	if r.Header.Get("If-Match") != "" {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input data.NewMovie
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
+
+	movie := data.Movie{ID: id}
+	movie.FromNewMovie(input)
+
+	vld := validator.New()
+	vld.Check(id <= app.config.movies.maxPutID, "id", fmt.Sprintf("must not be more than %d", app.config.movies.maxPutID))
+
+	if movie.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.CreateWithID(r.Context(), &movie)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionCreate, movie.ID)
+	})
+	if err != nil {
+		switch {
+		// The ID is taken by a soft deleted movie, or by a concurrent request.
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movie.ETag())
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 // deleteMovieHandler for the "DELETE" /v1/movies/:id" endpoint.
+// The movie is soft deleted, so it can be restored until it is purged.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := web.ReadIDParam(r)
 	if err != nil || id < 1 {
@@ -134,11 +332,43 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.repositories.Movies.Delete(id)
+	// The current version of the movie is only needed to evaluate the If-Match header.
+	// The movie is then only deleted if it is still at the checked version.
+	var version int32
+	if r.Header.Get("If-Match") != "" || app.config.preconditions.required {
+		movie, err := app.repositories.Movies.Read(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movie) {
+			return
+		}
+		version = movie.Version
+	}
+
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.Delete(r.Context(), id, version)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionDelete, id)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		// The movie changed since the If-Match header was evaluated.
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -157,8 +387,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 // readAllMoviesHandler for the "GET /v1/movies?..." endpoint.
 func (app *application) readAllMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieSearch
 		database.Filters
 	}
 
@@ -167,21 +396,46 @@ func (app *application) readAllMoviesHandler(w http.ResponseWriter, r *http.Requ
 
 	input.Title = web.ReadString(qs, "title", "")
 	input.Genres = web.ReadCSV(qs, "genres", []string{})
+	input.Person = web.ReadString(qs, "person", "")
+	input.Director = web.ReadString(qs, "director", "")
+	input.IncludeDeleted = web.ReadBool(qs, "include_deleted", false, vld)
 	input.Page = web.ReadInt(qs, "page", 1, vld)
 	input.PageSize = web.ReadInt(qs, "page_size", 20, vld)
 	input.Sort = web.ReadString(qs, "sort", "id")
-	input.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.SortSafelist = []string{
+		"id", "title", "year", "runtime", "average_rating", "review_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-review_count",
+	}
+
+	// The presence of the cursor parameter selects the keyset pagination;
+	// an empty cursor requests the first page.
+	input.Keyset = qs.Has("cursor")
+	if encoded := qs.Get("cursor"); encoded != "" {
+		cursor, err := database.DecodeCursor(encoded, app.config.cursor.secret)
+		if err != nil {
+			vld.AddError("cursor", "must be a valid cursor")
+		} else {
+			input.Cursor = cursor
+			// The sort parameter can be omitted when following a cursor.
+			input.Sort = web.ReadString(qs, "sort", cursor.Sort)
+		}
+	}
 
 	if input.ValidateFilters(vld); !vld.Valid() {
 		app.failedValidationResponse(w, r, vld.Errors)
 		return
 	}
 
-	movies, metadata, err := app.repositories.Movies.ReadAll(input.Title, input.Genres, input.Filters)
+	if input.IncludeDeleted && !app.checkIncludeDeleted(w, r) {
+		return
+	}
+
+	movies, metadata, err := app.repositories.Movies.ReadAll(r.Context(), input.MovieSearch, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	metadata.EncodeCursors(app.config.cursor.secret)
 
 	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
@@ -189,3 +443,156 @@ func (app *application) readAllMoviesHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 }
+
+// restoreMovieHandler for the "POST /v1/movies/:id/restore" endpoint.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.Restore(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionRestore, id)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.repositories.Movies.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movie.ETag())
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMoviePatch applies the body of a PATCH request to the movie, in the format of its
+// Content-Type: an UpdateMovie (application/json), a JSON Merge Patch (RFC 7396) or a
+// JSON Patch (RFC 6902). The patches apply to the fields of a NewMovie. It sends the
+// error response and returns false when the body can't be applied.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	supported := []string{"application/json", "application/merge-patch+json", "application/json-patch+json"}
+
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil || !validator.PermittedValue(mediaType, supported...) {
+			app.unsupportedMediaTypeResponse(w, r, supported...)
+			return false
+		}
+	}
+
+	if mediaType == "application/json" {
+		var input data.UpdateMovie
+		err := web.ReadJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		movie.FromUpdateMovie(input)
+		return true
+	}
+
+	patch, err := web.ReadBody(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	doc, err := json.Marshal(movie.AsNewMovie())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if mediaType == "application/merge-patch+json" {
+		doc, err = jsonpatch.Merge(doc, patch)
+	} else {
+		doc, err = jsonpatch.Apply(doc, patch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.patchTestFailedResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return false
+	}
+
+	var input data.NewMovie
+	err = web.DecodeJSON(bytes.NewReader(doc), &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	movie.FromNewMovie(input)
+	return true
+}
+
+// checkIncludeDeleted checks that the user can read the soft deleted movies, which
+// requires the movies:write permission. It sends the error response and returns
+// false when the request must not be processed.
+func (app *application) checkIncludeDeleted(w http.ResponseWriter, r *http.Request) bool {
+	permitted, err := app.hasPermission(r, data.PermissionMoviesWrite)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// checkIfMatch evaluates the If-Match header of the request against the current
+// version of the movie. It sends the error response and returns false when the
+// request must not be processed.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	match := r.Header.Get("If-Match")
+
+	if match == "" {
+		if app.config.preconditions.required {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	if !web.ETagMatches(match, movie.ETag(), false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/logger"
+)
+
+// newTestApplication creates an application backed by the memory repositories.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{
+		logger:       logger.New(io.Discard, logger.LevelOff),
+		repositories: data.NewMemoryRepositories(),
+	}
+	app.config.accessLog.format = accessLogOff
+	app.metrics = newAppMetrics(nil, &app.wg)
+
+	return app
+}
+
+// newTestUser creates an activated user with the permissions and returns
+// its authentication token.
+func newTestUser(t *testing.T, app *application, email string, permissions ...string) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user := &data.User{Name: "Test", Email: email, Activated: true}
+	err := app.repositories.Users.Create(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.repositories.Permissions.AddForUser(ctx, user.ID, permissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.repositories.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
+
+// serve sends the request through the routes of the application and returns the response.
+func serve(t *testing.T, h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestMovieRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	routes := app.routes()
+
+	reader := newTestUser(t, app, "reader@example.com", data.PermissionMoviesRead)
+	writer := newTestUser(t, app, "writer@example.com", data.PermissionMoviesRead, data.PermissionMoviesWrite)
+
+	const movie = `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"create without token", http.MethodPost, "/v1/movies", "", movie, http.StatusUnauthorized},
+		{"create without permission", http.MethodPost, "/v1/movies", reader, movie, http.StatusForbidden},
+		{"create invalid", http.MethodPost, "/v1/movies", writer, `{"title": ""}`, http.StatusUnprocessableEntity},
+		{"create", http.MethodPost, "/v1/movies", writer, movie, http.StatusCreated},
+		{"read", http.MethodGet, "/v1/movies/1", reader, "", http.StatusOK},
+		{"read missing", http.MethodGet, "/v1/movies/2", reader, "", http.StatusNotFound},
+		{"update", http.MethodPatch, "/v1/movies/1", writer, `{"year": 2017}`, http.StatusOK},
+		{"read revisions", http.MethodGet, "/v1/movies/1/revisions", reader, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := serve(t, routes, tt.method, tt.target, tt.token, tt.body)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: got status %d; want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+	}
+
+	w := serve(t, routes, http.MethodGet, "/v1/movies/1", reader, "")
+
+	var got struct {
+		Movie data.Movie `json:"movie"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Movie.Title != "Moana" || got.Movie.Year != 2017 || got.Movie.Version != 2 {
+		t.Errorf("got movie %+v; want Moana (2017) at version 2", got.Movie)
+	}
+
+	revision, err := app.repositories.MovieRevisions.Read(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := revision.Changes["year"]; !ok || len(revision.Changes) != 1 {
+		t.Errorf("got revision changes %v; want only the year", revision.Changes)
+	}
+}
+
+func TestPersonUpdateChangesCreditedMovieETag(t *testing.T) {
+	app := newTestApplication(t)
+	routes := app.routes()
+
+	writer := newTestUser(t, app, "writer@example.com", data.PermissionMoviesRead, data.PermissionMoviesWrite)
+
+	steps := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"create movie", http.MethodPost, "/v1/movies", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusCreated},
+		{"create person", http.MethodPost, "/v1/people", `{"name": "Auli'i Cravalho"}`, http.StatusCreated},
+		{"credit person", http.MethodPost, "/v1/movies/1/credits", `{"person_id": 1, "role": "actor", "character": "Moana"}`, http.StatusCreated},
+	}
+
+	for _, step := range steps {
+		w := serve(t, routes, step.method, step.target, writer, step.body)
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: got status %d; want %d: %s", step.name, w.Code, step.wantStatus, w.Body)
+		}
+	}
+
+	before := serve(t, routes, http.MethodGet, "/v1/movies/1", writer, "").Header().Get("ETag")
+
+	w := serve(t, routes, http.MethodPatch, "/v1/people/1", writer, `{"name": "Auli'i Cravalho Jr."}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update person: got status %d; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	after := serve(t, routes, http.MethodGet, "/v1/movies/1", writer, "").Header().Get("ETag")
+
+	if before == "" || before == after {
+		t.Errorf("got ETag %q before and %q after the update of the person; want them to differ", before, after)
+	}
+}
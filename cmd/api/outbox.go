@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/logger"
+)
+
+// startOutboxWorker launches a goroutine which polls the email outbox and sends
+// the pending emails until ctx is cancelled. The goroutine is tracked by app.wg,
+// so a graceful shutdown waits for the email currently being sent.
+func (app *application) startOutboxWorker(ctx context.Context) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.outbox.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			app.processOutbox(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// processOutbox sends up to a batch of due emails from the outbox.
+func (app *application) processOutbox(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	for i := 0; i < app.config.outbox.batchSize; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The email is processed with its own context rather than ctx, so a shutdown
+		// doesn't interrupt an email which is being sent.
+		email, err := app.repositories.Outbox.ProcessNext(
+			context.Background(),
+			app.config.outbox.maxAttempts,
+			app.config.outbox.backoff,
+			func(email *data.OutboxEmail) error {
+				err := app.mailer.Send(email.Recipient, email.Template, email.Data)
+				if err != nil {
+					app.metrics.emails.Inc("failure")
+					return err
+				}
+
+				app.metrics.emails.Inc("success")
+				return nil
+			},
+		)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		// Nothing is due at the moment.
+		if email == nil {
+			return
+		}
+
+		// The entries carry the ID of the request which queued the email.
+		log := app.logger
+		if email.RequestID != "" {
+			log = log.With(map[string]string{"request_id": email.RequestID})
+		}
+
+		switch email.Status {
+		case data.OutboxStatusDead:
+			log.PrintError(fmt.Errorf("email dead-lettered: %s", email.LastError), map[string]string{
+				"email_id": strconv.FormatInt(email.ID, 10),
+				"attempts": strconv.Itoa(email.Attempts),
+			})
+		case data.OutboxStatusPending:
+			// The email is retried, so the failure isn't an error yet.
+			log.Warn("email sending failed",
+				logger.String("error", email.LastError),
+				logger.Int64("email_id", email.ID),
+				logger.Int("attempts", email.Attempts),
+			)
+		}
+	}
+}
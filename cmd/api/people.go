@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// createPersonHandler for the "POST /v1/people" endpoint.
+func (app *application) createPersonHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		input  data.NewPerson
+		person data.Person
+	)
+
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+	person.FromNewPerson(input)
+
+	if person.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.People.Create(r.Context(), &person)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/people/%d", person.ID))
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"person": person}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPersonHandler for the "GET /v1/people/:id" endpoint.
+func (app *application) readPersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	person, err := app.repositories.People.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePersonHandler for the "PATCH /v1/people/:id" endpoint.
+func (app *application) updatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	person, err := app.repositories.People.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input data.UpdatePerson
+	err = web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+	person.FromUpdatePerson(input)
+	if person.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.People.Update(r.Context(), person)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deletePersonHandler for the "DELETE /v1/people/:id" endpoint.
+// The credits of the person are deleted as well.
+func (app *application) deletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repositories.People.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"message": "person successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAllPeopleHandler for the "GET /v1/people?..." endpoint.
+func (app *application) readAllPeopleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		database.Filters
+	}
+
+	vld := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = web.ReadString(qs, "name", "")
+	input.Page = web.ReadInt(qs, "page", 1, vld)
+	input.PageSize = web.ReadInt(qs, "page_size", 20, vld)
+	input.Sort = web.ReadString(qs, "sort", "id")
+	input.SortSafelist = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+
+	if input.ValidateFilters(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	people, metadata, err := app.repositories.People.ReadAll(r.Context(), input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"people": people, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
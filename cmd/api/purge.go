@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// startPurgeWorker launches a goroutine which periodically purges the movies soft deleted
+// for longer than the configured retention and the expired idempotency keys, until ctx
+// is cancelled. The goroutine is tracked by app.wg.
+func (app *application) startPurgeWorker(ctx context.Context) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.purge.interval)
+		defer ticker.Stop()
+
+		for {
+			app.purgeMovies(ctx)
+			app.purgeIdempotencyKeys(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeMovies permanently deletes the movies soft deleted before the retention.
+func (app *application) purgeMovies(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	purged, err := app.repositories.Movies.Purge(ctx, time.Now().Add(-app.config.purge.retention))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if purged > 0 {
+		app.logger.PrintInfo("purged deleted movies", map[string]string{
+			"movies": strconv.FormatInt(purged, 10),
+		})
+	}
+}
+
+// purgeIdempotencyKeys deletes the expired idempotency keys.
+func (app *application) purgeIdempotencyKeys(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	purged, err := app.repositories.IdempotencyKeys.DeleteExpired(ctx)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	if purged > 0 {
+		app.logger.PrintInfo("purged expired idempotency keys", map[string]string{
+			"keys": strconv.FormatInt(purged, 10),
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// createReviewHandler for the "POST /v1/movies/:id/reviews" endpoint.
+// The review is written by the authenticated user.
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input data.NewReview
+	err = web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := data.Review{
+		UserID:  app.contextGetUser(r).ID,
+		MovieID: movieID,
+	}
+	review.FromNewReview(input)
+
+	vld := validator.New()
+	if review.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Reviews.Create(r.Context(), &review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateReview):
+			vld.AddError("movie", "you have already reviewed this movie")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/reviews/%d", review.ID))
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAllReviewsHandler for the "GET /v1/movies/:id/reviews?..." endpoint.
+func (app *application) readAllReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		database.Filters
+	}
+
+	vld := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = web.ReadInt(qs, "page", 1, vld)
+	input.PageSize = web.ReadInt(qs, "page_size", 20, vld)
+	input.Sort = web.ReadString(qs, "sort", "-created_at")
+	input.SortSafelist = []string{"id", "rating", "created_at", "-id", "-rating", "-created_at"}
+
+	if input.ValidateFilters(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	// The movie is read so that unknown movies are reported as not found
+	// rather than as movies without reviews.
+	_, err = app.repositories.Movies.Read(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := app.repositories.Reviews.ReadAllForMovie(r.Context(), movieID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateReviewHandler for the "PATCH /v1/reviews/:id" endpoint.
+// Only the user who wrote the review can update it.
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readOwnReview(w, r)
+	if !ok {
+		return
+	}
+
+	var input data.UpdateReview
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review.FromUpdateReview(input)
+
+	vld := validator.New()
+	if review.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Reviews.Update(r.Context(), review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteReviewHandler for the "DELETE /v1/reviews/:id" endpoint.
+// Only the user who wrote the review can delete it.
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readOwnReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.repositories.Reviews.Delete(r.Context(), review.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOwnReview reads the review identified by the "id" URL parameter and checks
+// that it was written by the authenticated user, about a movie which isn't deleted. It sends the error response and
+// returns false when the request must not be processed.
+func (app *application) readOwnReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	id, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.repositories.Reviews.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	// The reviews of a soft deleted movie can't change, since they would change
+	// its rating aggregates.
+	_, err = app.repositories.Movies.Read(r.Context(), review.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return review, true
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// readAllMovieRevisionsHandler for the "GET /v1/movies/:id/revisions?..." endpoint.
+func (app *application) readAllMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		database.Filters
+	}
+
+	vld := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = web.ReadInt(qs, "page", 1, vld)
+	input.PageSize = web.ReadInt(qs, "page_size", 20, vld)
+	input.Sort = web.ReadString(qs, "sort", "-version")
+	input.SortSafelist = []string{"version", "-version"}
+
+	if input.ValidateFilters(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	// The movie is read so that unknown movies are reported as not found
+	// rather than as movies without revisions.
+	_, err = app.repositories.Movies.Read(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revisions, metadata, err := app.repositories.MovieRevisions.ReadAllForMovie(r.Context(), movieID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMovieRevisionHandler for the "GET /v1/movies/:id/revisions/:version" endpoint.
+func (app *application) readMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	revision, ok := app.readMovieRevision(w, r)
+	if !ok {
+		return
+	}
+
+	err := web.WriteJSON(w, http.StatusOK, web.Envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovieHandler for the "POST /v1/movies/:id/revisions/:version/revert" endpoint.
+// The fields of the movie are set back to their values at the revision, as an update
+// of the current version of the movie.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	revision, ok := app.readMovieRevision(w, r)
+	if !ok {
+		return
+	}
+
+	movie, err := app.repositories.Movies.Read(r.Context(), revision.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, movie) {
+		return
+	}
+
+	vld := validator.New()
+	vld.Check(revision.Version < movie.Version, "version", "must be an earlier version of the movie")
+
+	movie.Title = revision.Snapshot.Title
+	movie.Year = revision.Snapshot.Year
+	movie.Runtime = revision.Snapshot.Runtime
+	movie.Genres = revision.Snapshot.Genres
+
+	if movie.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		return app.recordMovieRevision(r, tx, data.RevisionRevert, movie.ID)
+	})
+	if err != nil {
+		switch {
+		// The movie changed since the If-Match header was evaluated.
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movie.ETag())
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMovieRevision reads the revision of the "id" and "version" parameters.
+// It sends the error response and returns false when the revision can't be read.
+func (app *application) readMovieRevision(w http.ResponseWriter, r *http.Request) (*data.MovieRevision, bool) {
+	movieID, err := web.ReadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	version, err := web.ReadInt64Param(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	revision, err := app.repositories.MovieRevisions.Read(r.Context(), movieID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return revision, true
+}
+
+// recordMovieRevision records the current state of the movie as a revision made by the
+// user of the request. It must run in the transaction of the change of the movie.
+func (app *application) recordMovieRevision(r *http.Request, tx data.Repositories, operation string, movieID int64) error {
+	movie, err := tx.Movies.ReadIncludingDeleted(r.Context(), movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.MovieRevisions.Create(r.Context(), data.NewMovieRevision(operation, movie, app.contextGetUser(r).ID))
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mroobert/json-api/internal/data"
 )
 
 // routes will create a router with the api endpoints.
@@ -14,15 +15,79 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	// handle registers the handler of a route, labelled with its pattern for the metrics.
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.routePattern(pattern, handler))
+	}
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.readAllMoviesHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.readMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	handle(http.MethodGet, "/v1/movies", app.requirePermission(data.PermissionMoviesRead, app.readAllMoviesHandler))
+	handle(http.MethodPost, "/v1/movies", app.requirePermission(data.PermissionMoviesWrite, app.idempotent(app.createMovieHandler)))
+	handle(http.MethodPost, "/v1/movies/:id", staticSegments(map[string]http.HandlerFunc{
+		"import": app.routePattern("/v1/movies/import", app.requirePermission(data.PermissionMoviesWrite, app.importMoviesHandler)),
+	}, app.methodNotAllowedResponse))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.updateMovieHandler))
+	handle(http.MethodPut, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.replaceMovieHandler))
+	handle(http.MethodGet, "/v1/movies/:id", staticSegments(map[string]http.HandlerFunc{
+		"export": app.routePattern("/v1/movies/export", app.requirePermission(data.PermissionMoviesRead, app.exportMoviesHandler)),
+	}, app.requirePermission(data.PermissionMoviesRead, app.readMovieHandler)))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieHandler))
 
-	return app.recoverPanic(app.rateLimit(router))
+	handle(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission(data.PermissionMoviesWrite, app.restoreMovieHandler))
+
+	handle(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission(data.PermissionMoviesRead, app.readAllMovieRevisionsHandler))
+	handle(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission(data.PermissionMoviesRead, app.readMovieRevisionHandler))
+	handle(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission(data.PermissionMoviesWrite, app.revertMovieHandler))
+
+	handle(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission(data.PermissionMoviesWrite, app.createCreditHandler))
+	handle(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission(data.PermissionMoviesWrite, app.deleteCreditHandler))
+
+	handle(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.readAllReviewsHandler))
+	handle(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.createReviewHandler))
+	handle(http.MethodPatch, "/v1/reviews/:id", app.requireActivatedUser(app.updateReviewHandler))
+	handle(http.MethodDelete, "/v1/reviews/:id", app.requireActivatedUser(app.deleteReviewHandler))
+
+	handle(http.MethodGet, "/v1/imports/:id", app.requirePermission(data.PermissionMoviesWrite, app.readImportJobHandler))
+
+	handle(http.MethodGet, "/v1/people", app.requirePermission(data.PermissionMoviesRead, app.readAllPeopleHandler))
+	handle(http.MethodPost, "/v1/people", app.requirePermission(data.PermissionMoviesWrite, app.createPersonHandler))
+	handle(http.MethodGet, "/v1/people/:id", app.requirePermission(data.PermissionMoviesRead, app.readPersonHandler))
+	handle(http.MethodPatch, "/v1/people/:id", app.requirePermission(data.PermissionMoviesWrite, app.updatePersonHandler))
+	handle(http.MethodDelete, "/v1/people/:id", app.requirePermission(data.PermissionMoviesWrite, app.deletePersonHandler))
+
+	handle(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+
+	handle(http.MethodGet, "/v1/users/:id/watchlists", app.requireSameUser(app.readAllWatchlistsHandler))
+	handle(http.MethodPost, "/v1/users/:id/watchlists", app.requireSameUser(app.createWatchlistHandler))
+	handle(http.MethodGet, "/v1/users/:id/watchlists/:watchlist_id", app.requireSameUser(app.readWatchlistHandler))
+	handle(http.MethodPatch, "/v1/users/:id/watchlists/:watchlist_id", app.requireSameUser(app.updateWatchlistHandler))
+	handle(http.MethodDelete, "/v1/users/:id/watchlists/:watchlist_id", app.requireSameUser(app.deleteWatchlistHandler))
+	handle(http.MethodGet, "/v1/users/:id/watchlists/:watchlist_id/movies", app.requireSameUser(app.readAllWatchlistMoviesHandler))
+	handle(http.MethodPost, "/v1/users/:id/watchlists/:watchlist_id/movies", app.requireSameUser(app.addWatchlistMovieHandler))
+	handle(http.MethodPatch, "/v1/users/:id/watchlists/:watchlist_id/movies/:movie_id", app.requireSameUser(app.updateWatchlistMovieHandler))
+	handle(http.MethodDelete, "/v1/users/:id/watchlists/:watchlist_id/movies/:movie_id", app.requireSameUser(app.removeWatchlistMovieHandler))
+
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	return app.recordMetrics(app.requestID(app.accessLog(app.recoverPanic(app.rateLimit(app.authenticate(router))))))
+}
+
+// staticSegments dispatches the requests whose "id" parameter is the static segment of
+// one of the handlers (e.g. "/v1/movies/import"), since httprouter doesn't allow to
+// register a static segment next to the ":id" wildcard. The other requests go to next.
+func staticSegments(handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,11 +16,25 @@ func (app *application) serve() error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// The background workers run until the server starts shutting down.
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var adminSrv *http.Server
+	if app.config.metrics.port != 0 {
+		adminSrv = app.serveAdmin()
+	}
+
+	app.startInterruptedImportsWorker(workersCtx)
+	app.startOutboxWorker(workersCtx)
+	app.startPurgeWorker(workersCtx)
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -39,10 +54,18 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		if adminSrv != nil {
+			err = adminSrv.Shutdown(ctx)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
 
+		stopWorkers()
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
@@ -1,18 +1,28 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+
+	"github.com/mroobert/json-api/internal/logger"
+)
+
+// background executes the specified fn in a separate goroutine. fn gets a context which
+// is not cancelled along with ctx, but carries its logger, so the entries of the task
+// have the ID of the request which started it.
+func (app *application) background(ctx context.Context, fn func(ctx context.Context)) {
+	log := app.logger.FromContext(ctx)
+	ctx = logger.NewContext(context.Background(), log)
 
-// background executes the specified fn in a separate goroutine.
-func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
 		defer app.wg.Done()
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				log.PrintError(fmt.Errorf("%s", err), nil)
 			}
 		}()
 
-		fn()
+		fn(ctx)
 	}()
 }
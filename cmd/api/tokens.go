@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// createAuthenticationTokenHandler for the "POST /v1/tokens/authentication" endpoint.
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+	data.ValidateEmail(vld, input.Email)
+	data.ValidatePasswordPlaintext(vld, input.Password)
+	if !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	user, err := app.repositories.Users.Read(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := app.repositories.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler for the "POST /v1/tokens/password-reset" endpoint.
+// The response is the same whether or not the email address belongs to an activated
+// user, so the endpoint can't be used to find out which accounts exist.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+	if data.ValidateEmail(vld, input.Email); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	env := web.Envelope{"message": "if the email address belongs to an activated account, you will receive an email containing password reset instructions"}
+
+	user, err := app.repositories.Users.Read(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = web.WriteJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+			token, err := tx.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+			if err != nil {
+				return err
+			}
+
+			return tx.Outbox.Create(r.Context(), &data.OutboxEmail{
+				Recipient: user.Email,
+				Template:  "token_password_reset.tmpl",
+				Data: map[string]any{
+					"passwordResetToken": token.Plaintext,
+				},
+				RequestID: app.contextGetRequestID(r),
+			})
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = web.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
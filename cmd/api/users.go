@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/mroobert/json-api/internal/data"
 	"github.com/mroobert/json-api/internal/validator"
@@ -26,6 +27,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	err = user.Password.Set(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	vld := validator.New()
@@ -34,7 +36,34 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.repositories.Users.Create(&user)
+	// The user, its default permissions, the activation token and the welcome email
+	// are persisted together, so the email is never lost or sent for a rolled back user.
+	err = app.repositories.Transaction(r.Context(), func(tx data.Repositories) error {
+		err := tx.Users.Create(r.Context(), &user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddForUser(r.Context(), user.ID, data.PermissionMoviesRead)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Create(r.Context(), &data.OutboxEmail{
+			Recipient: user.Email,
+			Template:  "user_welcome.tmpl",
+			Data: map[string]any{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			},
+			RequestID: app.contextGetRequestID(r),
+		})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -46,14 +75,126 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	app.background(func() {
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", user)
-		if err != nil {
-			app.logger.PrintError(err, nil)
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// activateUserHandler for the "PUT /v1/users/activated" endpoint.
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+	if data.ValidateTokenPlaintext(vld, input.TokenPlaintext); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	user, err := app.repositories.Users.ReadForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			vld.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-	})
+		return
+	}
 
-	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"user": user}, nil)
+	user.Activated = true
+
+	err = app.repositories.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.repositories.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserPasswordHandler for the "PUT /v1/users/password" endpoint.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	vld := validator.New()
+	data.ValidatePasswordPlaintext(vld, input.Password)
+	data.ValidateTokenPlaintext(vld, input.TokenPlaintext)
+	if !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	user, err := app.repositories.Users.ReadForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			vld.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.repositories.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Changing the password revokes every token of the user, including
+	// authentication tokens issued before the reset.
+	err = app.repositories.Tokens.DeleteAllScopesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"message": "your password was successfully reset"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
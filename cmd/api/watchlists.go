@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mroobert/json-api/internal/data"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+	"github.com/mroobert/json-api/internal/web"
+)
+
+// createWatchlistHandler for the "POST /v1/users/:id/watchlists" endpoint.
+func (app *application) createWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.NewWatchlist
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	watchlist := data.Watchlist{UserID: user.ID}
+	watchlist.FromNewWatchlist(input)
+
+	vld := validator.New()
+	if watchlist.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Watchlists.Create(r.Context(), &watchlist)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateWatchlist):
+			vld.AddError("name", "a watchlist with this name already exists")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d/watchlists/%d", user.ID, watchlist.ID))
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"watchlist": watchlist}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAllWatchlistsHandler for the "GET /v1/users/:id/watchlists?..." endpoint.
+func (app *application) readAllWatchlistsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		database.Filters
+	}
+
+	vld := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = web.ReadInt(qs, "page", 1, vld)
+	input.PageSize = web.ReadInt(qs, "page_size", 20, vld)
+	input.Sort = web.ReadString(qs, "sort", "id")
+	input.SortSafelist = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+
+	if input.ValidateFilters(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	watchlists, metadata, err := app.repositories.Watchlists.ReadAllForUser(r.Context(), app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"watchlists": watchlists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readWatchlistHandler for the "GET /v1/users/:id/watchlists/:watchlist_id" endpoint.
+func (app *application) readWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	err := web.WriteJSON(w, http.StatusOK, web.Envelope{"watchlist": watchlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWatchlistHandler for the "PATCH /v1/users/:id/watchlists/:watchlist_id" endpoint.
+func (app *application) updateWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	var input data.UpdateWatchlist
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	watchlist.FromUpdateWatchlist(input)
+
+	vld := validator.New()
+	if watchlist.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Watchlists.Update(r.Context(), watchlist)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateWatchlist):
+			vld.AddError("name", "a watchlist with this name already exists")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"watchlist": watchlist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWatchlistHandler for the "DELETE /v1/users/:id/watchlists/:watchlist_id" endpoint.
+func (app *application) deleteWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.repositories.Watchlists.Delete(r.Context(), watchlist.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"message": "watchlist successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addWatchlistMovieHandler for the "POST /v1/users/:id/watchlists/:watchlist_id/movies" endpoint.
+func (app *application) addWatchlistMovieHandler(w http.ResponseWriter, r *http.Request) {
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	var input data.NewWatchlistItem
+	err := web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item := data.WatchlistItem{WatchlistID: watchlist.ID}
+	item.FromNewWatchlistItem(input)
+
+	vld := validator.New()
+	if item.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	// The foreign key doesn't know about the soft deleted movies.
+	_, err = app.repositories.Movies.Read(r.Context(), item.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			vld.AddError("movie_id", "movie does not exist")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.repositories.Watchlists.AddItem(r.Context(), &item)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			vld.AddError("movie_id", "movie does not exist")
+			app.failedValidationResponse(w, r, vld.Errors)
+		case errors.Is(err, data.ErrDuplicateWatchlistMovie):
+			vld.AddError("movie_id", "movie is already in the watchlist")
+			app.failedValidationResponse(w, r, vld.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d/watchlists/%d/movies/%d", watchlist.UserID, watchlist.ID, item.MovieID))
+
+	err = web.WriteJSON(w, http.StatusCreated, web.Envelope{"item": item}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAllWatchlistMoviesHandler for the "GET /v1/users/:id/watchlists/:watchlist_id/movies?..." endpoint.
+func (app *application) readAllWatchlistMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		database.Filters
+	}
+
+	vld := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = web.ReadInt(qs, "page", 1, vld)
+	input.PageSize = web.ReadInt(qs, "page_size", 20, vld)
+	input.Sort = web.ReadString(qs, "sort", "position")
+	input.SortSafelist = []string{
+		"position", "added_at", "watched_at", "title", "year",
+		"-position", "-added_at", "-watched_at", "-title", "-year",
+	}
+
+	if input.ValidateFilters(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	items, metadata, err := app.repositories.Watchlists.ReadAllItems(r.Context(), watchlist.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"items": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWatchlistMovieHandler for the "PATCH /v1/users/:id/watchlists/:watchlist_id/movies/:movie_id" endpoint.
+// It moves the movie to another position and marks it as watched or unwatched.
+func (app *application) updateWatchlistMovieHandler(w http.ResponseWriter, r *http.Request) {
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := web.ReadInt64Param(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	item, err := app.repositories.Watchlists.ReadItem(r.Context(), watchlist.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input data.UpdateWatchlistItem
+	err = web.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item.FromUpdateWatchlistItem(input)
+
+	vld := validator.New()
+	if item.Validate(vld); !vld.Valid() {
+		app.failedValidationResponse(w, r, vld.Errors)
+		return
+	}
+
+	err = app.repositories.Watchlists.UpdateItem(r.Context(), item)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeWatchlistMovieHandler for the "DELETE /v1/users/:id/watchlists/:watchlist_id/movies/:movie_id" endpoint.
+func (app *application) removeWatchlistMovieHandler(w http.ResponseWriter, r *http.Request) {
+	watchlist, ok := app.readUserWatchlist(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := web.ReadInt64Param(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repositories.Watchlists.RemoveItem(r.Context(), watchlist.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = web.WriteJSON(w, http.StatusOK, web.Envelope{"message": "movie successfully removed from the watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserWatchlist reads the watchlist identified by the "watchlist_id" URL parameter
+// and checks that it belongs to the authenticated user. It sends the error response
+// and returns false when the request must not be processed.
+func (app *application) readUserWatchlist(w http.ResponseWriter, r *http.Request) (*data.Watchlist, bool) {
+	id, err := web.ReadInt64Param(r, "watchlist_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	watchlist, err := app.repositories.Watchlists.Read(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	// The watchlists of the other users are reported as not found,
+	// so their existence is not leaked.
+	if watchlist.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return watchlist, true
+}
@@ -1,6 +1,6 @@
 module github.com/mroobert/json-api
 
-go 1.19
+go 1.21
 
 require (
 	github.com/go-mail/mail/v2 v2.3.0
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+)
+
+//go:embed queries/credits/create.sql
+var createCreditSQL string
+
+//go:embed queries/credits/delete.sql
+var deleteCreditSQL string
+
+//go:embed queries/credits/read_all_for_movie.sql
+var readAllCreditsForMovieSQL string
+
+//go:embed queries/credits/touch_movie.sql
+var touchCreditMovieSQL string
+
+// Roles in which a person can be credited in a movie.
+const (
+	RoleActor    = "actor"
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+)
+
+type (
+	// Credit represents the participation of a person in a movie.
+	Credit struct {
+		ID           int64  `json:"id"`
+		MovieID      int64  `json:"-"`
+		PersonID     int64  `json:"person_id"`
+		Name         string `json:"name"` // Name of the person, read along with the credit
+		Role         string `json:"role"`
+		Character    string `json:"character,omitempty"` // Name of the character played by an actor
+		BillingOrder int32  `json:"billing_order"`
+	}
+
+	// NewCredit contains information needed to credit a person in a movie.
+	NewCredit struct {
+		PersonID     int64  `json:"person_id"`
+		Role         string `json:"role"`
+		Character    string `json:"character"`
+		BillingOrder int32  `json:"billing_order"`
+	}
+
+	// CreditRepository manages the set of APIs for credit database access.
+	// The credits are part of the movie representation, so every change of
+	// the credits increments the version of the movie in the same transaction.
+	CreditRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+func (c Credit) Validate(vld *validator.Validator) {
+	vld.Check(c.PersonID > 0, "person_id", "must be provided")
+
+	vld.Check(c.Role != "", "role", "must be provided")
+	vld.Check(validator.PermittedValue(c.Role, RoleActor, RoleDirector, RoleWriter), "role", "must be one of actor, director or writer")
+
+	vld.Check(c.Character == "" || c.Role == RoleActor, "character", "must only be provided for actors")
+	vld.Check(len(c.Character) <= 500, "character", "must not be more than 500 bytes long")
+
+	vld.Check(c.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+func (c *Credit) FromNewCredit(input NewCredit) {
+	c.PersonID = input.PersonID
+	c.Role = input.Role
+	c.Character = input.Character
+	c.BillingOrder = input.BillingOrder
+}
+
+// Create will insert a new credit in the database.
+func (r CreditRepository) Create(ctx context.Context, credit *Credit) error {
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, createCreditSQL, args...).Scan(&credit.ID)
+		if err != nil {
+			var pgError *pgconn.PgError
+			if errors.As(err, &pgError) {
+				switch pgError.Code {
+				case database.UniqueViolation:
+					return ErrDuplicateCredit
+				case database.ForeignKeyViolation:
+					return ErrRecordNotFound
+				}
+			}
+			return err
+		}
+
+		_, err = tx.Exec(ctx, touchCreditMovieSQL, credit.MovieID)
+		return err
+	})
+
+	return queryError(err)
+}
+
+// Delete will delete a credit of a movie from the database.
+func (r CreditRepository) Delete(ctx context.Context, movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, deleteCreditSQL, id, movieID)
+		if err != nil {
+			return err
+		}
+
+		if result.RowsAffected() == 0 {
+			return ErrRecordNotFound
+		}
+
+		_, err = tx.Exec(ctx, touchCreditMovieSQL, movieID)
+		return err
+	})
+
+	return queryError(err)
+}
+
+// ReadAllForMovie will fetch the credits of a movie, in their billing order.
+func (r CreditRepository) ReadAllForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.Query(ctx, readAllCreditsForMovieSQL, movieID)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, queryError(err)
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, queryError(err)
+	}
+
+	return credits, nil
+}
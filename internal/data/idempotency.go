@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mroobert/json-api/internal/database"
+)
+
+//go:embed queries/idempotency/claim.sql
+var claimIdempotencyKeySQL string
+
+//go:embed queries/idempotency/read.sql
+var readIdempotencyKeySQL string
+
+//go:embed queries/idempotency/complete.sql
+var completeIdempotencyKeySQL string
+
+//go:embed queries/idempotency/release.sql
+var releaseIdempotencyKeySQL string
+
+//go:embed queries/idempotency/delete_expired.sql
+var deleteExpiredIdempotencyKeysSQL string
+
+type (
+	// IdempotencyKey represents the use of an Idempotency-Key header on a route, along with
+	// the response of the first request which used it. The status is 0 while that request
+	// is in flight.
+	IdempotencyKey struct {
+		Key         string
+		Route       string // Method and path of the request
+		CreatedAt   time.Time
+		ExpiresAt   time.Time
+		LockedUntil time.Time // End of the lease of the request in flight
+		Fingerprint []byte    // Hash of the request, to recognize the retries
+		Status      int
+		Header      http.Header
+		Body        []byte
+	}
+
+	// IdempotencyKeyRepository manages the set of APIs for idempotency key database access.
+	IdempotencyKeyRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+// Claim will insert the idempotency key in the database, as in flight, unless it is already
+// used and not expired. A key still in flight whose lease ended, because its request never
+// completed (e.g. the process crashed), is taken over by a retry of the same request.
+// It reports whether the key was claimed; if not, the key is filled with the stored one.
+func (r IdempotencyKeyRepository) Claim(ctx context.Context, key *IdempotencyKey) (bool, error) {
+	args := []any{key.Key, key.Route, key.Fingerprint, key.ExpiresAt, key.LockedUntil}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, claimIdempotencyKeySQL, args...).Scan(&key.CreatedAt)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, queryError(err)
+	}
+
+	var (
+		status *int
+		header []byte
+	)
+
+	err = r.DB.QueryRow(ctx, readIdempotencyKeySQL, key.Key, key.Route).Scan(
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.Fingerprint,
+		&status,
+		&header,
+		&key.Body,
+	)
+	if err != nil {
+		switch {
+		// The first request failed and released the key in the meantime,
+		// which is reported as still in flight.
+		case errors.Is(err, pgx.ErrNoRows):
+			return false, nil
+		default:
+			return false, queryError(err)
+		}
+	}
+
+	if status != nil {
+		key.Status = *status
+
+		err = json.Unmarshal(header, &key.Header)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// Complete will store the response of the request which claimed the idempotency key.
+// A key which was already completed, by a retry which took it over, is left as it is.
+func (r IdempotencyKeyRepository) Complete(ctx context.Context, key *IdempotencyKey) error {
+	header, err := json.Marshal(key.Header)
+	if err != nil {
+		return err
+	}
+
+	args := []any{key.Key, key.Route, key.Status, header, key.Body}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err = r.DB.Exec(ctx, completeIdempotencyKeySQL, args...)
+	return queryError(err)
+}
+
+// Release will delete an idempotency key which is still in flight,
+// so that the request can be retried with the same key.
+func (r IdempotencyKeyRepository) Release(ctx context.Context, key *IdempotencyKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.Exec(ctx, releaseIdempotencyKeySQL, key.Key, key.Route)
+	return queryError(err)
+}
+
+// DeleteExpired will delete the expired idempotency keys,
+// and returns how many were deleted.
+func (r IdempotencyKeyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.Exec(ctx, deleteExpiredIdempotencyKeysSQL)
+	if err != nil {
+		return 0, queryError(err)
+	}
+
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mroobert/json-api/internal/database"
+)
+
+//go:embed queries/imports/create.sql
+var createImportJobSQL string
+
+//go:embed queries/imports/read.sql
+var readImportJobSQL string
+
+//go:embed queries/imports/start.sql
+var startImportJobSQL string
+
+//go:embed queries/imports/update.sql
+var updateImportJobSQL string
+
+//go:embed queries/imports/renew.sql
+var renewImportJobSQL string
+
+//go:embed queries/imports/fail_interrupted.sql
+var failInterruptedImportJobsSQL string
+
+// Statuses of an import job.
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// Statuses of an imported row.
+const (
+	ImportRowAccepted = "accepted"
+	ImportRowRejected = "rejected"
+)
+
+type (
+	// ImportRow reports the outcome of a single line of an import.
+	ImportRow struct {
+		Line   int               `json:"line"`
+		Status string            `json:"status"`
+		Errors map[string]string `json:"errors,omitempty"` // Why the row was rejected
+	}
+
+	// ImportReport reports the outcome of every line of an import.
+	ImportReport struct {
+		Accepted int         `json:"accepted"`
+		Rejected int         `json:"rejected"`
+		Rows     []ImportRow `json:"rows"`
+	}
+
+	// ImportJob represents an import which runs in the background, in the process
+	// which accepted it. The process holds a lease on the job, which it renews
+	// while the job runs.
+	ImportJob struct {
+		ID          int64     `json:"id"`
+		CreatedAt   time.Time `json:"created_at"`
+		UpdatedAt   time.Time `json:"updated_at"`
+		UserID      int64     `json:"-"`
+		Format      string    `json:"format"`
+		Status      string    `json:"status"`
+		Error       string    `json:"error,omitempty"` // Why the import failed
+		Owner       string    `json:"-"`               // Process running the job
+		LockedUntil time.Time `json:"-"`               // End of the lease of the owner
+		ImportReport
+	}
+
+	// ImportJobRepository manages the set of APIs for import job database access.
+	ImportJobRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+// Create will insert a new pending import job in the database.
+func (r ImportJobRepository) Create(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	args := []any{job.UserID, job.Format, job.Owner, job.LockedUntil}
+
+	err := r.DB.QueryRow(ctx, createImportJobSQL, args...).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt, &job.Status)
+	return queryError(err)
+}
+
+// Read will fetch an import job from the database.
+func (r ImportJobRepository) Read(ctx context.Context, id int64) (*ImportJob, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var (
+		job    ImportJob
+		report []byte
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, readImportJobSQL, id).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.UserID,
+		&job.Format,
+		&job.Status,
+		&job.Accepted,
+		&job.Rejected,
+		&report,
+		&job.Error,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	err = json.Unmarshal(report, &job.Rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Start will mark a pending import job as running.
+// A job which is no longer pending is left as it is and ErrEditConflict is returned.
+func (r ImportJobRepository) Start(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, startImportJobSQL, job.ID).Scan(&job.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(err)
+		}
+	}
+
+	job.Status = ImportStatusRunning
+
+	return nil
+}
+
+// Update will store the status and the report of a running import job. A job which
+// is no longer running, e.g. because it was failed as interrupted after its lease
+// expired, is left as it is and ErrEditConflict is returned.
+func (r ImportJobRepository) Update(ctx context.Context, job *ImportJob) error {
+	rows := job.Rows
+	if rows == nil {
+		rows = []ImportRow{}
+	}
+
+	report, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+
+	args := []any{job.Status, job.Accepted, job.Rejected, report, job.Error, job.ID}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err = r.DB.QueryRow(ctx, updateImportJobSQL, args...).Scan(&job.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(err)
+		}
+	}
+
+	return nil
+}
+
+// Renew will extend the lease of the owner on a pending or running import job until
+// lockedUntil. It returns ErrEditConflict when the job is no longer pending nor running,
+// or is owned by another process.
+func (r ImportJobRepository) Renew(ctx context.Context, id int64, owner string, lockedUntil time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.Exec(ctx, renewImportJobSQL, id, owner, lockedUntil)
+	if err != nil {
+		return queryError(err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// FailInterrupted marks the pending or running import jobs whose lease expired as failed
+// with the message, and returns how many were marked. The lease of a job expires when its
+// owner stops renewing it, which means the process running the job crashed, so it's safe
+// to call while other processes run their own jobs.
+func (r ImportJobRepository) FailInterrupted(ctx context.Context, message string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.Exec(ctx, failInterruptedImportJobsSQL, message)
+	if err != nil {
+		return 0, queryError(err)
+	}
+
+	return result.RowsAffected(), nil
+}
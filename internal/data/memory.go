@@ -0,0 +1,860 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mroobert/json-api/internal/database"
+)
+
+type (
+	// MemoryMovieStore is an in-memory MovieStore, meant to be used in tests.
+	// It mirrors the behaviour of MovieRepository, including the optimistic locking.
+	MemoryMovieStore struct {
+		mu     sync.Mutex
+		lastID int64
+		movies map[int64]Movie
+	}
+
+	// MemoryMovieRevisionStore is an in-memory MovieRevisionStore, meant to be used in tests.
+	// Like MovieRevisionRepository, it computes the changes of the revisions it creates.
+	MemoryMovieRevisionStore struct {
+		mu        sync.Mutex
+		revisions map[int64][]MovieRevision // Revisions of every movie, by version
+	}
+
+	// MemoryOutboxStore is an in-memory OutboxStore, meant to be used in tests.
+	// Like OutboxRepository, it retries the failed emails with an exponential backoff
+	// and dead-letters them after the maximum number of attempts.
+	MemoryOutboxStore struct {
+		mu     sync.Mutex
+		lastID int64
+		emails []memoryOutboxEmail
+	}
+
+	// memoryOutboxEmail is an email of the MemoryOutboxStore, with the time of its next attempt.
+	memoryOutboxEmail struct {
+		OutboxEmail
+		nextAttemptAt time.Time
+	}
+
+	// MemoryPermissionStore is an in-memory PermissionStore, meant to be used in tests.
+	MemoryPermissionStore struct {
+		mu          sync.Mutex
+		permissions map[int64]Permissions
+	}
+
+	// MemoryTokenStore is an in-memory TokenStore, meant to be used in tests.
+	// The tokens are kept by the MemoryUserStore, so their users can be fetched
+	// with its ReadForToken.
+	MemoryTokenStore struct {
+		users *MemoryUserStore
+	}
+
+	// MemoryUserStore is an in-memory UserStore, meant to be used in tests.
+	// It mirrors the behaviour of UserRepository, including the optimistic locking
+	// and the case-insensitive uniqueness of the email addresses.
+	MemoryUserStore struct {
+		mu     sync.Mutex
+		lastID int64
+		users  map[int64]User
+		tokens map[[sha256.Size]byte]Token
+	}
+)
+
+// NewMemoryMovieStore creates an empty MemoryMovieStore.
+func NewMemoryMovieStore() *MemoryMovieStore {
+	return &MemoryMovieStore{movies: make(map[int64]Movie)}
+}
+
+// Create will insert a new movie in the store.
+func (s *MemoryMovieStore) Create(ctx context.Context, movie *Movie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	movie.ID = s.lastID
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.Version = 1
+
+	s.movies[movie.ID] = copyMovie(*movie)
+
+	return nil
+}
+
+// CreateWithID will insert a new movie with the ID chosen by the client in the store.
+func (s *MemoryMovieStore) CreateWithID(ctx context.Context, movie *Movie) error {
+	if movie.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.movies[movie.ID]; ok {
+		return ErrDuplicateMovie
+	}
+
+	if movie.ID > s.lastID {
+		s.lastID = movie.ID
+	}
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.Version = 1
+
+	s.movies[movie.ID] = copyMovie(*movie)
+
+	return nil
+}
+
+// CreateMany will insert the movies in the store.
+func (s *MemoryMovieStore) CreateMany(ctx context.Context, movies []*Movie) error {
+	for _, movie := range movies {
+		err := s.Create(ctx, movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read will fetch a movie from the store.
+// The soft deleted movies are not found.
+func (s *MemoryMovieStore) Read(ctx context.Context, id int64) (*Movie, error) {
+	return s.read(id, false)
+}
+
+// ReadIncludingDeleted will fetch a movie from the store, even if it is soft deleted.
+func (s *MemoryMovieStore) ReadIncludingDeleted(ctx context.Context, id int64) (*Movie, error) {
+	return s.read(id, true)
+}
+
+func (s *MemoryMovieStore) read(id int64, includeDeleted bool) (*Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	movie, ok := s.movies[id]
+	if !ok || movie.DeletedAt != nil && !includeDeleted {
+		return nil, ErrRecordNotFound
+	}
+
+	movie = copyMovie(movie)
+	return &movie, nil
+}
+
+// Update will update a movie from the store.
+// This operation is implementing optimistic locking.
+func (s *MemoryMovieStore) Update(ctx context.Context, movie *Movie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.movies[movie.ID]
+	if !ok || stored.Version != movie.Version || stored.DeletedAt != nil {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	stored.Title = movie.Title
+	stored.Year = movie.Year
+	stored.Runtime = movie.Runtime
+	stored.Genres = movie.Genres
+	stored.Version = movie.Version
+	movie.AverageRating = stored.AverageRating
+	movie.ReviewCount = stored.ReviewCount
+
+	s.movies[movie.ID] = copyMovie(stored)
+
+	return nil
+}
+
+// Delete will soft delete a movie from the store.
+// When version isn't 0, this operation is implementing optimistic locking.
+func (s *MemoryMovieStore) Delete(ctx context.Context, id int64, version int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	movie, ok := s.movies[id]
+	if !ok || movie.DeletedAt != nil || version != 0 && movie.Version != version {
+		if version != 0 {
+			return ErrEditConflict
+		}
+		return ErrRecordNotFound
+	}
+
+	now := time.Now().Truncate(time.Second)
+	movie.DeletedAt = &now
+	movie.Version++
+	s.movies[id] = movie
+
+	return nil
+}
+
+// Restore will restore a soft deleted movie.
+func (s *MemoryMovieStore) Restore(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	movie, ok := s.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	movie.DeletedAt = nil
+	movie.Version++
+	s.movies[id] = movie
+
+	return nil
+}
+
+// Purge will permanently delete the movies soft deleted before the given time,
+// and returns how many were deleted.
+func (s *MemoryMovieStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, movie := range s.movies {
+		if movie.DeletedAt != nil && movie.DeletedAt.Before(before) {
+			delete(s.movies, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// ReadAll will fetch all movies based on the provided parameters.
+// The title matches when it contains all the words of the searched title,
+// which approximates the full-text search used by MovieRepository.
+// The store keeps no credits, so searching by person or director matches no movie.
+func (s *MemoryMovieStore) ReadAll(ctx context.Context, search MovieSearch, filters database.Filters) ([]*Movie, database.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []Movie{}
+	for _, movie := range s.movies {
+		if movie.DeletedAt != nil && !search.IncludeDeleted {
+			continue
+		}
+
+		credited := search.Person == "" && search.Director == ""
+		if credited && matchesWords(movie.Title, search.Title) && containsAll(movie.Genres, search.Genres) {
+			matches = append(matches, movie)
+		}
+	}
+
+	column, desc := filters.SortColumn(), filters.SortDirection() == "DESC"
+	less := func(a, b Movie) bool {
+		cmp := compareMovies(a, b, column)
+		if cmp == 0 {
+			return a.ID < b.ID
+		}
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return less(matches[i], matches[j])
+	})
+
+	if filters.Keyset {
+		return memoryKeysetPage(matches, column, filters, less)
+	}
+
+	totalRecords := len(matches)
+
+	movies := []*Movie{}
+	for i := filters.Offset(); i < totalRecords && len(movies) < filters.Limit(); i++ {
+		movie := copyMovie(matches[i])
+		movies = append(movies, &movie)
+	}
+
+	return movies, database.NewMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// ReadEach calls fn for every movie matching the search, in the order of their IDs
+// and up to limit movies. The store is not locked while fn runs.
+func (s *MemoryMovieStore) ReadEach(ctx context.Context, search MovieSearch, limit int, fn func(*Movie) error) error {
+	s.mu.Lock()
+	count := len(s.movies)
+	s.mu.Unlock()
+
+	movies, _, err := s.ReadAll(ctx, search, database.Filters{
+		Page:         1,
+		PageSize:     count + 1,
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, movie := range movies {
+		if i == limit {
+			break
+		}
+
+		err := fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exists checks if the movie is in the store, even if it is soft deleted.
+func (s *MemoryMovieStore) exists(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.movies[id]
+	return ok
+}
+
+// touch increments the versions of the movies, as a change of their credits does.
+// The movies which are not in the store are skipped.
+func (s *MemoryMovieStore) touch(ids ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if movie, ok := s.movies[id]; ok {
+			movie.Version++
+			s.movies[id] = movie
+		}
+	}
+}
+
+// setRating stores the average rating and the review count of the movie.
+func (s *MemoryMovieStore) setRating(id int64, averageRating float64, reviewCount int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if movie, ok := s.movies[id]; ok {
+		movie.AverageRating = averageRating
+		movie.ReviewCount = reviewCount
+		s.movies[id] = movie
+	}
+}
+
+// memoryKeysetPage selects the page after (or before) the cursor of the filters
+// from the sorted movies.
+func memoryKeysetPage(sorted []Movie, column string, filters database.Filters, less func(a, b Movie) bool) ([]*Movie, database.Metadata, error) {
+	var (
+		cursor   = filters.Cursor
+		backward = cursor != nil && cursor.Backward
+		at       Movie
+	)
+
+	if cursor != nil {
+		value, err := parseMovieSortValue(column, cursor.Value)
+		if err != nil {
+			return nil, database.Metadata{}, err
+		}
+
+		at.ID = cursor.ID
+		switch column {
+		case "title":
+			at.Title = value.(string)
+		case "year":
+			at.Year = int32(value.(int64))
+		case "runtime":
+			at.Runtime = Runtime(value.(int64))
+		case "average_rating":
+			at.AverageRating = value.(float64)
+		case "review_count":
+			at.ReviewCount = int32(value.(int64))
+		case "id":
+			at.ID = value.(int64)
+		}
+	}
+
+	// As in the SQL query, the movies are collected in the walking direction
+	// and one more than the page size is kept to find out if there are more.
+	movies := []*Movie{}
+	for i := range sorted {
+		movie := sorted[i]
+		if backward {
+			movie = sorted[len(sorted)-1-i]
+		}
+
+		switch {
+		case cursor == nil:
+		case backward && !less(movie, at):
+			continue
+		case !backward && !less(at, movie):
+			continue
+		}
+
+		movie = copyMovie(movie)
+		movies = append(movies, &movie)
+		if len(movies) > filters.Limit() {
+			break
+		}
+	}
+
+	movies, metadata := keysetPage(movies, column, filters)
+
+	return movies, metadata, nil
+}
+
+// NewMemoryMovieRevisionStore creates an empty MemoryMovieRevisionStore.
+func NewMemoryMovieRevisionStore() *MemoryMovieRevisionStore {
+	return &MemoryMovieRevisionStore{revisions: make(map[int64][]MovieRevision)}
+}
+
+// Create will insert a new revision in the store. The changes of the revision are
+// the differences with the previous revision of the movie.
+func (s *MemoryMovieRevisionStore) Create(ctx context.Context, revision *MovieRevision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var previous *MovieSnapshot
+
+	revisions := s.revisions[revision.MovieID]
+	for i := range revisions {
+		if revisions[i].Version < revision.Version {
+			previous = &revisions[i].Snapshot
+		}
+	}
+
+	revision.Changes = revision.Snapshot.changes(previous)
+	revision.CreatedAt = time.Now().Truncate(time.Second)
+
+	s.insert(*revision)
+
+	return nil
+}
+
+// CreateMany will insert the revisions of new movies in the store.
+// As with MovieRevisionRepository, the creation times are not set on the revisions.
+func (s *MemoryMovieRevisionStore) CreateMany(ctx context.Context, revisions []*MovieRevision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, revision := range revisions {
+		revision.Changes = revision.Snapshot.changes(nil)
+
+		stored := *revision
+		stored.CreatedAt = time.Now().Truncate(time.Second)
+		s.insert(stored)
+	}
+
+	return nil
+}
+
+// Read will fetch a revision of a movie from the store.
+func (s *MemoryMovieRevisionStore) Read(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, revision := range s.revisions[movieID] {
+		if revision.Version == version {
+			revision = copyRevision(revision)
+			return &revision, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// ReadAllForMovie will fetch the revisions of a movie based on the provided filters.
+// The revisions can only be sorted by version.
+func (s *MemoryMovieRevisionStore) ReadAllForMovie(ctx context.Context, movieID int64, filters database.Filters) ([]*MovieRevision, database.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.revisions[movieID]
+	totalRecords := len(stored)
+
+	revisions := []*MovieRevision{}
+	for i := filters.Offset(); i < totalRecords && len(revisions) < filters.Limit(); i++ {
+		j := i
+		if filters.SortDirection() == "DESC" {
+			j = totalRecords - 1 - i
+		}
+
+		revision := copyRevision(stored[j])
+		revisions = append(revisions, &revision)
+	}
+
+	return revisions, database.NewMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// insert adds the revision to the ones of its movie, keeping them sorted by version.
+func (s *MemoryMovieRevisionStore) insert(revision MovieRevision) {
+	revisions := append(s.revisions[revision.MovieID], copyRevision(revision))
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Version < revisions[j].Version
+	})
+	s.revisions[revision.MovieID] = revisions
+}
+
+// NewMemoryOutboxStore creates an empty MemoryOutboxStore.
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{}
+}
+
+// Create will insert a new email in the outbox.
+func (s *MemoryOutboxStore) Create(ctx context.Context, email *OutboxEmail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	email.ID = s.lastID
+	email.CreatedAt = time.Now().Truncate(time.Second)
+	email.Status = OutboxStatusPending
+
+	s.emails = append(s.emails, memoryOutboxEmail{OutboxEmail: *email, nextAttemptAt: email.CreatedAt})
+
+	return nil
+}
+
+// ProcessNext calls send for the next due email from the outbox and records the outcome,
+// like OutboxRepository.ProcessNext. The store is locked while send runs.
+//
+// It returns the processed email, or nil if there was no email due.
+func (s *MemoryOutboxStore) ProcessNext(ctx context.Context, maxAttempts int, baseBackoff time.Duration, send func(*OutboxEmail) error) (*OutboxEmail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var next *memoryOutboxEmail
+	for i := range s.emails {
+		email := &s.emails[i]
+		if email.Status != OutboxStatusPending || email.nextAttemptAt.After(now) {
+			continue
+		}
+		if next == nil || email.nextAttemptAt.Before(next.nextAttemptAt) {
+			next = email
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	email := next.OutboxEmail
+	email.Attempts++
+	err := send(&email)
+	switch {
+	case err == nil:
+		email.Status = OutboxStatusSent
+		email.LastError = ""
+	case email.Attempts >= maxAttempts:
+		email.Status = OutboxStatusDead
+		email.LastError = err.Error()
+	default:
+		email.LastError = err.Error()
+		backoff := baseBackoff << (email.Attempts - 1)
+		if backoff <= 0 || backoff > maxOutboxBackoff {
+			backoff = maxOutboxBackoff
+		}
+		next.nextAttemptAt = now.Add(backoff)
+	}
+
+	next.OutboxEmail = email
+	if email.Status != OutboxStatusPending {
+		next.Data = nil
+	}
+
+	return &email, nil
+}
+
+// NewMemoryPermissionStore creates an empty MemoryPermissionStore.
+func NewMemoryPermissionStore() *MemoryPermissionStore {
+	return &MemoryPermissionStore{permissions: make(map[int64]Permissions)}
+}
+
+// GetAllForUser will fetch all permission codes for a specific user.
+func (s *MemoryPermissionStore) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(Permissions(nil), s.permissions[userID]...), nil
+}
+
+// AddForUser will add the permission codes for a specific user.
+// The codes the user already has are skipped.
+func (s *MemoryPermissionStore) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range codes {
+		if !s.permissions[userID].Include(code) {
+			s.permissions[userID] = append(s.permissions[userID], code)
+		}
+	}
+
+	return nil
+}
+
+// NewMemoryTokenStore creates a MemoryTokenStore keeping its tokens in the users store.
+func NewMemoryTokenStore(users *MemoryUserStore) *MemoryTokenStore {
+	return &MemoryTokenStore{users: users}
+}
+
+// New generates a new token for the given user and adds it to the store.
+func (s *MemoryTokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	s.users.AddToken(token)
+	return token, nil
+}
+
+// DeleteAllForUser will delete all tokens for a specific user and scope.
+func (s *MemoryTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	s.users.deleteTokens(func(token Token) bool {
+		return token.UserID == userID && token.Scope == scope
+	})
+	return nil
+}
+
+// DeleteAllScopesForUser will delete every token of a specific user, regardless of scope.
+func (s *MemoryTokenStore) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	s.users.deleteTokens(func(token Token) bool {
+		return token.UserID == userID
+	})
+	return nil
+}
+
+// NewMemoryUserStore creates an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:  make(map[int64]User),
+		tokens: make(map[[sha256.Size]byte]Token),
+	}
+}
+
+// Create will insert a new user in the store.
+func (s *MemoryUserStore) Create(ctx context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	s.lastID++
+	user.ID = s.lastID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	s.users[user.ID] = *user
+
+	return nil
+}
+
+// Read will fetch a user by email from the store.
+func (s *MemoryUserStore) Read(ctx context.Context, email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// ReadForToken will fetch the user associated with a token of the given scope.
+// The tokens are registered with AddToken.
+func (s *MemoryUserStore) ReadForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[sha256.Sum256([]byte(tokenPlaintext))]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := s.users[token.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &user, nil
+}
+
+// Update will update a user from the store.
+// This operation is implementing optimistic locking.
+func (s *MemoryUserStore) Update(ctx context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	stored, ok := s.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+	s.users[user.ID] = *user
+
+	return nil
+}
+
+// AddToken registers a token, so the user it belongs to can be fetched with ReadForToken.
+func (s *MemoryUserStore) AddToken(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[sha256.Sum256([]byte(token.Plaintext))] = *token
+}
+
+// deleteTokens deletes the tokens matching the predicate.
+func (s *MemoryUserStore) deleteTokens(match func(Token) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.tokens {
+		if match(token) {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+// emailTaken checks if the email is used by a user other than the one with exceptID.
+// Emails are compared case-insensitively, like the citext column of the users table.
+func (s *MemoryUserStore) emailTaken(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryPage sorts the records with less and returns the page of them selected
+// by the filters, along with the metadata of the page.
+func memoryPage[T any](records []T, filters database.Filters, less func(a, b T) bool) ([]*T, database.Metadata) {
+	sort.Slice(records, func(i, j int) bool {
+		return less(records[i], records[j])
+	})
+
+	page := []*T{}
+	for i := filters.Offset(); i < len(records) && len(page) < filters.Limit(); i++ {
+		record := records[i]
+		page = append(page, &record)
+	}
+
+	return page, database.NewMetadata(len(records), filters.Page, filters.PageSize)
+}
+
+// sortedBefore reports whether a record comes before another, given the comparison of
+// their sort column values in the direction of the filters, and the comparison of their
+// tiebreaker values, which are always in ascending order.
+func sortedBefore(filters database.Filters, cmp, tiebreaker int) bool {
+	switch {
+	case cmp == 0:
+		return tiebreaker < 0
+	case filters.SortDirection() == "DESC":
+		return cmp > 0
+	default:
+		return cmp < 0
+	}
+}
+
+// copyMovie returns a copy of the movie which doesn't share the genres slice.
+func copyMovie(m Movie) Movie {
+	if m.Genres != nil {
+		m.Genres = append([]string{}, m.Genres...)
+	}
+	return m
+}
+
+// copyRevision returns a copy of the revision which doesn't share the genres
+// of its snapshot nor its changes.
+func copyRevision(rv MovieRevision) MovieRevision {
+	if rv.Snapshot.Genres != nil {
+		rv.Snapshot.Genres = append([]string{}, rv.Snapshot.Genres...)
+	}
+	if rv.Changes != nil {
+		changes := make(map[string]FieldChange, len(rv.Changes))
+		for field, change := range rv.Changes {
+			changes[field] = change
+		}
+		rv.Changes = changes
+	}
+	return rv
+}
+
+// compareMovies compares two movies by the given sort column.
+func compareMovies(a, b Movie, column string) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return int(a.Year - b.Year)
+	case "runtime":
+		return int(a.Runtime - b.Runtime)
+	case "average_rating":
+		switch {
+		case a.AverageRating < b.AverageRating:
+			return -1
+		case a.AverageRating > b.AverageRating:
+			return 1
+		}
+		return 0
+	case "review_count":
+		return int(a.ReviewCount - b.ReviewCount)
+	default:
+		switch {
+		case a.ID < b.ID:
+			return -1
+		case a.ID > b.ID:
+			return 1
+		}
+		return 0
+	}
+}
+
+// matchesWords checks if text contains all the words of query, ignoring case.
+// An empty query matches everything.
+func matchesWords(text, query string) bool {
+	split := func(s string) []string {
+		return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+	}
+
+	words := split(text)
+	for _, q := range split(query) {
+		if !containsAll(words, []string{q}) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAll checks if values contains every element of subset.
+func containsAll(values, subset []string) bool {
+	for _, s := range subset {
+		found := false
+		for _, v := range values {
+			if v == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryIdempotencyKeyStore is an in-memory IdempotencyKeyStore, meant to be used in tests.
+	// It mirrors the behaviour of IdempotencyKeyRepository, including the take over of
+	// the keys whose lease ended.
+	MemoryIdempotencyKeyStore struct {
+		mu   sync.Mutex
+		keys map[idempotencyKeyID]IdempotencyKey
+	}
+
+	// idempotencyKeyID identifies a key of the MemoryIdempotencyKeyStore.
+	idempotencyKeyID struct {
+		key   string
+		route string
+	}
+)
+
+// NewMemoryIdempotencyKeyStore creates an empty MemoryIdempotencyKeyStore.
+func NewMemoryIdempotencyKeyStore() *MemoryIdempotencyKeyStore {
+	return &MemoryIdempotencyKeyStore{keys: make(map[idempotencyKeyID]IdempotencyKey)}
+}
+
+// Claim will insert the idempotency key in the store, as in flight, unless it is already
+// used and not expired. A key still in flight whose lease ended is taken over by a retry
+// of the same request. It reports whether the key was claimed; if not, the key is filled
+// with the stored one.
+func (s *MemoryIdempotencyKeyStore) Claim(ctx context.Context, key *IdempotencyKey) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	id := idempotencyKeyID{key.Key, key.Route}
+
+	stored, ok := s.keys[id]
+	if ok && stored.ExpiresAt.After(now) &&
+		(stored.Status != 0 || stored.LockedUntil.After(now) || !bytes.Equal(stored.Fingerprint, key.Fingerprint)) {
+		key.CreatedAt = stored.CreatedAt
+		key.ExpiresAt = stored.ExpiresAt
+		key.Fingerprint = bytes.Clone(stored.Fingerprint)
+		key.Status = stored.Status
+		key.Header = stored.Header.Clone()
+		key.Body = bytes.Clone(stored.Body)
+		return false, nil
+	}
+
+	key.CreatedAt = now
+	s.keys[id] = IdempotencyKey{
+		Key:         key.Key,
+		Route:       key.Route,
+		CreatedAt:   key.CreatedAt,
+		ExpiresAt:   key.ExpiresAt,
+		LockedUntil: key.LockedUntil,
+		Fingerprint: bytes.Clone(key.Fingerprint),
+	}
+
+	return true, nil
+}
+
+// Complete will store the response of the request which claimed the idempotency key.
+// A key which was already completed, by a retry which took it over, is left as it is.
+func (s *MemoryIdempotencyKeyStore) Complete(ctx context.Context, key *IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKeyID{key.Key, key.Route}
+
+	stored, ok := s.keys[id]
+	if !ok || stored.Status != 0 {
+		return nil
+	}
+
+	stored.Status = key.Status
+	stored.Header = key.Header.Clone()
+	stored.Body = bytes.Clone(key.Body)
+	s.keys[id] = stored
+
+	return nil
+}
+
+// Release will delete an idempotency key which is still in flight,
+// so that the request can be retried with the same key.
+func (s *MemoryIdempotencyKeyStore) Release(ctx context.Context, key *IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKeyID{key.Key, key.Route}
+	if stored, ok := s.keys[id]; ok && stored.Status == 0 {
+		delete(s.keys, id)
+	}
+
+	return nil
+}
+
+// DeleteExpired will delete the expired idempotency keys,
+// and returns how many were deleted.
+func (s *MemoryIdempotencyKeyStore) DeleteExpired(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var deleted int64
+	for id, key := range s.keys {
+		if !key.ExpiresAt.After(now) {
+			delete(s.keys, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryImportJobStore is an in-memory ImportJobStore, meant to be used in tests.
+// It mirrors the behaviour of ImportJobRepository, including the leases of the jobs.
+type MemoryImportJobStore struct {
+	mu     sync.Mutex
+	lastID int64
+	jobs   map[int64]ImportJob
+}
+
+// NewMemoryImportJobStore creates an empty MemoryImportJobStore.
+func NewMemoryImportJobStore() *MemoryImportJobStore {
+	return &MemoryImportJobStore{jobs: make(map[int64]ImportJob)}
+}
+
+// Create will insert a new pending import job in the store.
+func (s *MemoryImportJobStore) Create(ctx context.Context, job *ImportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	job.ID = s.lastID
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+	job.Status = ImportStatusPending
+
+	s.insert(*job)
+
+	return nil
+}
+
+// Read will fetch an import job from the store.
+func (s *MemoryImportJobStore) Read(ctx context.Context, id int64) (*ImportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	job.Rows = append([]ImportRow{}, job.Rows...)
+	return &job, nil
+}
+
+// Start will mark a pending import job as running.
+// A job which is no longer pending is left as it is and ErrEditConflict is returned.
+func (s *MemoryImportJobStore) Start(ctx context.Context, job *ImportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[job.ID]
+	if !ok || stored.Status != ImportStatusPending {
+		return ErrEditConflict
+	}
+
+	stored.Status = ImportStatusRunning
+	stored.UpdatedAt = time.Now()
+	s.jobs[job.ID] = stored
+
+	job.Status = stored.Status
+	job.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+// Update will store the status and the report of a running import job.
+// A job which is no longer running is left as it is and ErrEditConflict is returned.
+func (s *MemoryImportJobStore) Update(ctx context.Context, job *ImportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[job.ID]
+	if !ok || stored.Status != ImportStatusRunning {
+		return ErrEditConflict
+	}
+
+	job.UpdatedAt = time.Now()
+	stored.Status = job.Status
+	stored.Error = job.Error
+	stored.UpdatedAt = job.UpdatedAt
+	stored.ImportReport = job.ImportReport
+	s.insert(stored)
+
+	return nil
+}
+
+// Renew will extend the lease of the owner on a pending or running import job until
+// lockedUntil. It returns ErrEditConflict when the job is no longer pending nor running,
+// or is owned by another process.
+func (s *MemoryImportJobStore) Renew(ctx context.Context, id int64, owner string, lockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Owner != owner || !importJobActive(job) {
+		return ErrEditConflict
+	}
+
+	job.LockedUntil = lockedUntil
+	s.jobs[id] = job
+
+	return nil
+}
+
+// FailInterrupted marks the pending or running import jobs whose lease expired as failed
+// with the message, and returns how many were marked.
+func (s *MemoryImportJobStore) FailInterrupted(ctx context.Context, message string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var failed int64
+	for id, job := range s.jobs {
+		if importJobActive(job) && !job.LockedUntil.After(now) {
+			job.Status = ImportStatusFailed
+			job.Error = message
+			job.UpdatedAt = now
+			s.jobs[id] = job
+			failed++
+		}
+	}
+
+	return failed, nil
+}
+
+// insert stores a copy of the job which doesn't share its rows.
+func (s *MemoryImportJobStore) insert(job ImportJob) {
+	job.Rows = append([]ImportRow{}, job.Rows...)
+	s.jobs[job.ID] = job
+}
+
+// importJobActive reports whether the job is pending or running.
+func importJobActive(job ImportJob) bool {
+	return job.Status == ImportStatusPending || job.Status == ImportStatusRunning
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mroobert/json-api/internal/database"
+)
+
+type (
+	// MemoryPersonStore is an in-memory PersonStore, meant to be used in tests.
+	// It mirrors the behaviour of PersonRepository, including the optimistic locking.
+	// It also keeps the credits, which are deleted along with their person, and
+	// increments the versions of the credited movies of the MemoryMovieStore.
+	MemoryPersonStore struct {
+		mu           sync.Mutex
+		movies       *MemoryMovieStore
+		lastID       int64
+		lastCreditID int64
+		people       map[int64]Person
+		credits      map[int64]Credit
+	}
+
+	// MemoryCreditStore is an in-memory CreditStore, meant to be used in tests.
+	// The credits are kept by the MemoryPersonStore, so they can be read with
+	// the names of their people.
+	MemoryCreditStore struct {
+		people *MemoryPersonStore
+	}
+)
+
+// NewMemoryPersonStore creates an empty MemoryPersonStore crediting the movies of the store.
+func NewMemoryPersonStore(movies *MemoryMovieStore) *MemoryPersonStore {
+	return &MemoryPersonStore{
+		movies:  movies,
+		people:  make(map[int64]Person),
+		credits: make(map[int64]Credit),
+	}
+}
+
+// Create will insert a new person in the store.
+func (s *MemoryPersonStore) Create(ctx context.Context, person *Person) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	person.ID = s.lastID
+	person.CreatedAt = time.Now().Truncate(time.Second)
+	person.Version = 1
+
+	s.people[person.ID] = *person
+
+	return nil
+}
+
+// Read will fetch a person from the store.
+func (s *MemoryPersonStore) Read(ctx context.Context, id int64) (*Person, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	person, ok := s.people[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &person, nil
+}
+
+// Update will update a person from the store.
+// This operation is implementing optimistic locking.
+// The version of every movie crediting the person is incremented.
+func (s *MemoryPersonStore) Update(ctx context.Context, person *Person) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.people[person.ID]
+	if !ok || stored.Version != person.Version {
+		return ErrEditConflict
+	}
+
+	person.Version++
+	stored.Name = person.Name
+	stored.BirthYear = person.BirthYear
+	stored.Biography = person.Biography
+	stored.Version = person.Version
+	s.people[person.ID] = stored
+
+	s.movies.touch(s.creditedMovies(person.ID)...)
+
+	return nil
+}
+
+// Delete will delete a person, together with the credits of the person, from the store.
+// The version of every movie crediting the person is incremented.
+func (s *MemoryPersonStore) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.people[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	s.movies.touch(s.creditedMovies(id)...)
+
+	for creditID, credit := range s.credits {
+		if credit.PersonID == id {
+			delete(s.credits, creditID)
+		}
+	}
+	delete(s.people, id)
+
+	return nil
+}
+
+// ReadAll will fetch all people based on the provided parameters.
+// The name matches when it contains all the words of the searched name,
+// which approximates the full-text search used by PersonRepository.
+func (s *MemoryPersonStore) ReadAll(ctx context.Context, name string, filters database.Filters) ([]*Person, database.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []Person{}
+	for _, person := range s.people {
+		if matchesWords(person.Name, name) {
+			matches = append(matches, person)
+		}
+	}
+
+	column := filters.SortColumn()
+	people, metadata := memoryPage(matches, filters, func(a, b Person) bool {
+		var c int
+		switch column {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "birth_year":
+			c = cmp.Compare(a.BirthYear, b.BirthYear)
+		default:
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		return sortedBefore(filters, c, cmp.Compare(a.ID, b.ID))
+	})
+
+	return people, metadata, nil
+}
+
+// creditedMovies returns the IDs of the movies crediting the person.
+func (s *MemoryPersonStore) creditedMovies(personID int64) []int64 {
+	var ids []int64
+	for _, credit := range s.credits {
+		if credit.PersonID == personID {
+			ids = append(ids, credit.MovieID)
+		}
+	}
+	return ids
+}
+
+// NewMemoryCreditStore creates a MemoryCreditStore keeping its credits in the people store.
+func NewMemoryCreditStore(people *MemoryPersonStore) *MemoryCreditStore {
+	return &MemoryCreditStore{people: people}
+}
+
+// Create will insert a new credit in the store and increment the version of the movie.
+// The movie, even if soft deleted, and the person must exist.
+func (s *MemoryCreditStore) Create(ctx context.Context, credit *Credit) error {
+	s.people.mu.Lock()
+	defer s.people.mu.Unlock()
+
+	if _, ok := s.people.people[credit.PersonID]; !ok || !s.people.movies.exists(credit.MovieID) {
+		return ErrRecordNotFound
+	}
+
+	for _, stored := range s.people.credits {
+		if stored.MovieID == credit.MovieID && stored.PersonID == credit.PersonID &&
+			stored.Role == credit.Role && stored.Character == credit.Character {
+			return ErrDuplicateCredit
+		}
+	}
+
+	s.people.lastCreditID++
+	credit.ID = s.people.lastCreditID
+	s.people.credits[credit.ID] = *credit
+
+	s.people.movies.touch(credit.MovieID)
+
+	return nil
+}
+
+// Delete will delete a credit of a movie from the store
+// and increment the version of the movie.
+func (s *MemoryCreditStore) Delete(ctx context.Context, movieID, id int64) error {
+	s.people.mu.Lock()
+	defer s.people.mu.Unlock()
+
+	credit, ok := s.people.credits[id]
+	if !ok || credit.MovieID != movieID {
+		return ErrRecordNotFound
+	}
+
+	delete(s.people.credits, id)
+	s.people.movies.touch(movieID)
+
+	return nil
+}
+
+// ReadAllForMovie will fetch the credits of a movie, in their billing order.
+func (s *MemoryCreditStore) ReadAllForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	s.people.mu.Lock()
+	defer s.people.mu.Unlock()
+
+	credits := []Credit{}
+	for _, credit := range s.people.credits {
+		if credit.MovieID == movieID {
+			credit.Name = s.people.people[credit.PersonID].Name
+			credits = append(credits, credit)
+		}
+	}
+
+	sort.Slice(credits, func(i, j int) bool {
+		if credits[i].BillingOrder != credits[j].BillingOrder {
+			return credits[i].BillingOrder < credits[j].BillingOrder
+		}
+		return credits[i].ID < credits[j].ID
+	})
+
+	page := make([]*Credit, len(credits))
+	for i := range credits {
+		page[i] = &credits[i]
+	}
+
+	return page, nil
+}
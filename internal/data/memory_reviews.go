@@ -0,0 +1,153 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mroobert/json-api/internal/database"
+)
+
+// MemoryReviewStore is an in-memory ReviewStore, meant to be used in tests.
+// It mirrors the behaviour of ReviewRepository, including the optimistic locking,
+// and updates the average rating and the review count of the movies of the MemoryMovieStore.
+type MemoryReviewStore struct {
+	mu      sync.Mutex
+	movies  *MemoryMovieStore
+	lastID  int64
+	reviews map[int64]Review
+}
+
+// NewMemoryReviewStore creates an empty MemoryReviewStore reviewing the movies of the store.
+func NewMemoryReviewStore(movies *MemoryMovieStore) *MemoryReviewStore {
+	return &MemoryReviewStore{movies: movies, reviews: make(map[int64]Review)}
+}
+
+// Create will insert a new review in the store.
+// A user can review a movie only once, and can't review a soft deleted movie.
+func (s *MemoryReviewStore) Create(ctx context.Context, review *Review) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.movies.read(review.MovieID, false); err != nil {
+		return err
+	}
+
+	for _, stored := range s.reviews {
+		if stored.UserID == review.UserID && stored.MovieID == review.MovieID {
+			return ErrDuplicateReview
+		}
+	}
+
+	s.lastID++
+	review.ID = s.lastID
+	review.CreatedAt = time.Now().Truncate(time.Second)
+	review.UpdatedAt = review.CreatedAt
+	review.Version = 1
+
+	s.reviews[review.ID] = *review
+	s.updateMovieRating(review.MovieID)
+
+	return nil
+}
+
+// Read will fetch a review from the store.
+func (s *MemoryReviewStore) Read(ctx context.Context, id int64) (*Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, ok := s.reviews[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &review, nil
+}
+
+// Update will update a review from the store.
+// This operation is implementing optimistic locking.
+func (s *MemoryReviewStore) Update(ctx context.Context, review *Review) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.reviews[review.ID]
+	if !ok || stored.Version != review.Version {
+		return ErrEditConflict
+	}
+
+	review.Version++
+	review.UpdatedAt = time.Now().Truncate(time.Second)
+	stored.Rating = review.Rating
+	stored.Body = review.Body
+	stored.UpdatedAt = review.UpdatedAt
+	stored.Version = review.Version
+
+	s.reviews[review.ID] = stored
+	s.updateMovieRating(stored.MovieID)
+
+	return nil
+}
+
+// Delete will delete a review from the store.
+func (s *MemoryReviewStore) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, ok := s.reviews[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(s.reviews, id)
+	s.updateMovieRating(review.MovieID)
+
+	return nil
+}
+
+// ReadAllForMovie will fetch the reviews of a movie based on the provided filters.
+func (s *MemoryReviewStore) ReadAllForMovie(ctx context.Context, movieID int64, filters database.Filters) ([]*Review, database.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []Review{}
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			matches = append(matches, review)
+		}
+	}
+
+	column := filters.SortColumn()
+	reviews, metadata := memoryPage(matches, filters, func(a, b Review) bool {
+		var c int
+		switch column {
+		case "rating":
+			c = cmp.Compare(a.Rating, b.Rating)
+		case "created_at":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		default:
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		return sortedBefore(filters, c, cmp.Compare(a.ID, b.ID))
+	})
+
+	return reviews, metadata, nil
+}
+
+// updateMovieRating recomputes the average rating and the review count of the movie.
+func (s *MemoryReviewStore) updateMovieRating(movieID int64) {
+	var sum, count int32
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			sum += review.Rating
+			count++
+		}
+	}
+
+	var average float64
+	if count > 0 {
+		average = float64(sum) / float64(count)
+	}
+
+	s.movies.setRating(movieID, average, count)
+}
@@ -0,0 +1,335 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mroobert/json-api/internal/database"
+)
+
+type (
+	// MemoryWatchlistStore is an in-memory WatchlistStore, meant to be used in tests.
+	// It mirrors the behaviour of WatchlistRepository, including the optimistic locking
+	// of the watchlists and the contiguous positions of their items.
+	MemoryWatchlistStore struct {
+		mu         sync.Mutex
+		movies     *MemoryMovieStore
+		lastID     int64
+		watchlists map[int64]Watchlist
+		items      map[watchlistItemKey]WatchlistItem
+	}
+
+	// watchlistItemKey identifies an item of the MemoryWatchlistStore.
+	watchlistItemKey struct {
+		watchlistID int64
+		movieID     int64
+	}
+)
+
+// NewMemoryWatchlistStore creates an empty MemoryWatchlistStore listing the movies of the store.
+func NewMemoryWatchlistStore(movies *MemoryMovieStore) *MemoryWatchlistStore {
+	return &MemoryWatchlistStore{
+		movies:     movies,
+		watchlists: make(map[int64]Watchlist),
+		items:      make(map[watchlistItemKey]WatchlistItem),
+	}
+}
+
+// Create will insert a new watchlist in the store.
+// The names of the watchlists of a user are unique.
+func (s *MemoryWatchlistStore) Create(ctx context.Context, watchlist *Watchlist) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nameTaken(watchlist.UserID, watchlist.Name, 0) {
+		return ErrDuplicateWatchlist
+	}
+
+	s.lastID++
+	watchlist.ID = s.lastID
+	watchlist.CreatedAt = time.Now().Truncate(time.Second)
+	watchlist.UpdatedAt = watchlist.CreatedAt
+	watchlist.Version = 1
+
+	s.watchlists[watchlist.ID] = *watchlist
+
+	return nil
+}
+
+// Read will fetch a watchlist from the store.
+func (s *MemoryWatchlistStore) Read(ctx context.Context, id int64) (*Watchlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchlist, ok := s.watchlists[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &watchlist, nil
+}
+
+// Update will update a watchlist from the store.
+// This operation is implementing optimistic locking.
+func (s *MemoryWatchlistStore) Update(ctx context.Context, watchlist *Watchlist) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.watchlists[watchlist.ID]
+	if !ok || stored.Version != watchlist.Version {
+		return ErrEditConflict
+	}
+
+	if s.nameTaken(stored.UserID, watchlist.Name, watchlist.ID) {
+		return ErrDuplicateWatchlist
+	}
+
+	watchlist.Version++
+	watchlist.UpdatedAt = time.Now().Truncate(time.Second)
+	stored.Name = watchlist.Name
+	stored.UpdatedAt = watchlist.UpdatedAt
+	stored.Version = watchlist.Version
+
+	s.watchlists[watchlist.ID] = stored
+
+	return nil
+}
+
+// Delete will delete a watchlist, together with its items, from the store.
+func (s *MemoryWatchlistStore) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.watchlists[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	for key := range s.items {
+		if key.watchlistID == id {
+			delete(s.items, key)
+		}
+	}
+	delete(s.watchlists, id)
+
+	return nil
+}
+
+// ReadAllForUser will fetch the watchlists of a user based on the provided filters.
+func (s *MemoryWatchlistStore) ReadAllForUser(ctx context.Context, userID int64, filters database.Filters) ([]*Watchlist, database.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []Watchlist{}
+	for _, watchlist := range s.watchlists {
+		if watchlist.UserID == userID {
+			matches = append(matches, watchlist)
+		}
+	}
+
+	column := filters.SortColumn()
+	watchlists, metadata := memoryPage(matches, filters, func(a, b Watchlist) bool {
+		var c int
+		switch column {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "created_at":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		default:
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		return sortedBefore(filters, c, cmp.Compare(a.ID, b.ID))
+	})
+
+	return watchlists, metadata, nil
+}
+
+// AddItem will insert a movie in a watchlist, at the position of the item.
+// The items from that position onwards are moved one position down. A missing
+// or out of range position appends the movie at the end of the watchlist.
+func (s *MemoryWatchlistStore) AddItem(ctx context.Context, item *WatchlistItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.watchlists[item.WatchlistID]; !ok || !s.movies.exists(item.MovieID) {
+		return ErrRecordNotFound
+	}
+
+	key := watchlistItemKey{item.WatchlistID, item.MovieID}
+	if _, ok := s.items[key]; ok {
+		return ErrDuplicateWatchlistMovie
+	}
+
+	count := s.countItems(item.WatchlistID)
+	if item.Position < 1 || item.Position > count+1 {
+		item.Position = count + 1
+	}
+
+	s.shiftItems(item.WatchlistID, item.Position, count, 1)
+
+	item.AddedAt = time.Now()
+	s.items[key] = WatchlistItem{
+		WatchlistID: item.WatchlistID,
+		MovieID:     item.MovieID,
+		Position:    item.Position,
+		AddedAt:     item.AddedAt,
+	}
+
+	return nil
+}
+
+// ReadItem will fetch a movie of a watchlist from the store.
+func (s *MemoryWatchlistStore) ReadItem(ctx context.Context, watchlistID, movieID int64) (*WatchlistItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[watchlistItemKey{watchlistID, movieID}]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &item, nil
+}
+
+// UpdateItem will update the position and the watched time of a movie of a watchlist.
+// The items between the old and the new position are moved by one position to make
+// room for the item. An out of range position moves the item at the end of the watchlist.
+func (s *MemoryWatchlistStore) UpdateItem(ctx context.Context, item *WatchlistItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.watchlists[item.WatchlistID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	key := watchlistItemKey{item.WatchlistID, item.MovieID}
+	current, ok := s.items[key]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	count := s.countItems(item.WatchlistID)
+	if item.Position < 1 || item.Position > count {
+		item.Position = count
+	}
+
+	switch {
+	case item.Position < current.Position:
+		s.shiftItems(item.WatchlistID, item.Position, current.Position-1, 1)
+	case item.Position > current.Position:
+		s.shiftItems(item.WatchlistID, current.Position+1, item.Position, -1)
+	}
+
+	current.Position = item.Position
+	current.WatchedAt = item.WatchedAt
+	s.items[key] = current
+
+	return nil
+}
+
+// RemoveItem will delete a movie from a watchlist.
+// The items after it are moved one position up.
+func (s *MemoryWatchlistStore) RemoveItem(ctx context.Context, watchlistID, movieID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.watchlists[watchlistID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	key := watchlistItemKey{watchlistID, movieID}
+	item, ok := s.items[key]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	count := s.countItems(watchlistID)
+	delete(s.items, key)
+	s.shiftItems(watchlistID, item.Position+1, count, -1)
+
+	return nil
+}
+
+// ReadAllItems will fetch the movies of a watchlist based on the provided filters.
+// The items carry the movie they refer to; the soft deleted movies are left out.
+func (s *MemoryWatchlistStore) ReadAllItems(ctx context.Context, watchlistID int64, filters database.Filters) ([]*WatchlistItem, database.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []WatchlistItem{}
+	for key, item := range s.items {
+		if key.watchlistID != watchlistID {
+			continue
+		}
+
+		movie, err := s.movies.read(item.MovieID, false)
+		if err != nil {
+			continue
+		}
+
+		item.Movie = movie
+		matches = append(matches, item)
+	}
+
+	column := filters.SortColumn()
+	items, metadata := memoryPage(matches, filters, func(a, b WatchlistItem) bool {
+		var c int
+		switch column {
+		case "added_at":
+			c = a.AddedAt.Compare(b.AddedAt)
+		case "watched_at":
+			// The items not watched yet come last in both directions.
+			switch {
+			case a.WatchedAt == nil || b.WatchedAt == nil:
+				if a.WatchedAt != b.WatchedAt {
+					return b.WatchedAt == nil
+				}
+			default:
+				c = a.WatchedAt.Compare(*b.WatchedAt)
+			}
+		case "title":
+			c = strings.Compare(a.Movie.Title, b.Movie.Title)
+		case "year":
+			c = cmp.Compare(a.Movie.Year, b.Movie.Year)
+		default:
+			c = cmp.Compare(a.Position, b.Position)
+		}
+		return sortedBefore(filters, c, cmp.Compare(a.Position, b.Position))
+	})
+
+	return items, metadata, nil
+}
+
+// nameTaken reports whether the user has a watchlist, other than exceptID, with the name.
+func (s *MemoryWatchlistStore) nameTaken(userID int64, name string, exceptID int64) bool {
+	for _, watchlist := range s.watchlists {
+		if watchlist.UserID == userID && watchlist.Name == name && watchlist.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// countItems returns the number of items of the watchlist.
+func (s *MemoryWatchlistStore) countItems(watchlistID int64) int32 {
+	var count int32
+	for key := range s.items {
+		if key.watchlistID == watchlistID {
+			count++
+		}
+	}
+	return count
+}
+
+// shiftItems moves by delta the positions of the items of the watchlist
+// whose positions are between from and to.
+func (s *MemoryWatchlistStore) shiftItems(watchlistID int64, from, to, delta int32) {
+	for key, item := range s.items {
+		if key.watchlistID == watchlistID && item.Position >= from && item.Position <= to {
+			item.Position += delta
+			s.items[key] = item
+		}
+	}
+}
@@ -5,10 +5,11 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/mroobert/json-api/internal/database"
 	"github.com/mroobert/json-api/internal/validator"
 )
@@ -16,6 +17,18 @@ import (
 //go:embed queries/movies/create.sql
 var createMovieSQL string
 
+//go:embed queries/movies/create_with_id.sql
+var createMovieWithIDSQL string
+
+//go:embed queries/movies/advance_id.sql
+var advanceMovieIDSQL string
+
+//go:embed queries/movies/lock_ids.sql
+var lockMovieIDsSQL string
+
+//go:embed queries/movies/next_ids.sql
+var nextMovieIDsSQL string
+
 //go:embed queries/movies/read.sql
 var readMovieSQL string
 
@@ -25,6 +38,16 @@ var updateMovieSQL string
 //go:embed queries/movies/delete.sql
 var deleteMovieSQL string
 
+//go:embed queries/movies/restore.sql
+var restoreMovieSQL string
+
+//go:embed queries/movies/purge.sql
+var purgeMoviesSQL string
+
+var (
+	ErrDuplicateMovie = errors.New("duplicate movie")
+)
+
 type (
 	// Movie represents an individual movie.
 	Movie struct {
@@ -35,11 +58,30 @@ type (
 		Runtime   Runtime   `json:"runtime,omitempty"` // Movie runtime (in minutes)
 		Genres    []string  `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.)
 		Version   int32     `json:"version"`           // The version number starts at 1 and will be incremented each time the movie information is updated
+
+		AverageRating float64 `json:"average_rating"` // Average rating of the reviews (0 without reviews)
+		ReviewCount   int32   `json:"review_count"`   // Number of reviews
+
+		Credits []*Credit `json:"credits,omitempty"` // Cast and crew, only read along with a single movie
+
+		DeletedAt *time.Time `json:"deleted_at,omitempty"` // Timestamp for when the movie was soft deleted
+	}
+
+	// MovieSearch holds the criteria the movies read by ReadAll must match.
+	// The empty fields match every movie.
+	MovieSearch struct {
+		Title    string   // Full-text search of the title
+		Genres   []string // Genres the movie must have, all of them
+		Person   string   // Full-text search of the name of anyone credited in the movie
+		Director string   // Full-text search of the name of a director of the movie
+
+		IncludeDeleted bool // Include the soft deleted movies
 	}
 
 	// MovieRepository manages the set of APIs for movie database access.
 	MovieRepository struct {
-		DB *pgxpool.Pool
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
 	}
 
 	// NewMovie contains information needed to create a new movie.
@@ -79,6 +121,36 @@ func (m Movie) Validate(vld *validator.Validator) {
 	vld.Check(validator.Unique(m.Genres), "genres", "must not contain duplicate values")
 }
 
+// movieSearchConditions is the WHERE clause of the movies matching a MovieSearch,
+// whose fields are the $1 to $5 parameters of the query.
+const movieSearchConditions = `
+        (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+        AND (genres @> $2 OR $2 = '{}')
+        AND ($3 = '' OR EXISTS (
+            SELECT 1
+            FROM movie_credits
+            INNER JOIN people ON people.id = movie_credits.person_id
+            WHERE movie_credits.movie_id = movies.id
+            AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $3)))
+        AND ($4 = '' OR EXISTS (
+            SELECT 1
+            FROM movie_credits
+            INNER JOIN people ON people.id = movie_credits.person_id
+            WHERE movie_credits.movie_id = movies.id AND movie_credits.role = 'director'
+            AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $4)))
+        AND (deleted_at IS NULL OR $5)`
+
+// args returns the parameters of movieSearchConditions.
+func (s MovieSearch) args() []any {
+	return []any{s.Title, s.Genres, s.Person, s.Director, s.IncludeDeleted}
+}
+
+// ETag returns the entity tag of the movie, which changes with every update. The rating
+// aggregates are part of it, since they change with the reviews rather than the version.
+func (m Movie) ETag() string {
+	return fmt.Sprintf(`"%d-%d-%d-%g"`, m.ID, m.Version, m.ReviewCount, m.AverageRating)
+}
+
 func (m *Movie) FromNewMovie(input NewMovie) {
 	m.Title = input.Title
 	m.Year = input.Year
@@ -86,6 +158,16 @@ func (m *Movie) FromNewMovie(input NewMovie) {
 	m.Genres = input.Genres
 }
 
+// AsNewMovie returns the fields of the movie which are set by the clients.
+func (m Movie) AsNewMovie() NewMovie {
+	return NewMovie{
+		Title:   m.Title,
+		Year:    m.Year,
+		Runtime: m.Runtime,
+		Genres:  m.Genres,
+	}
+}
+
 func (m *Movie) FromUpdateMovie(input UpdateMovie) {
 	if input.Title != nil {
 		m.Title = *input.Title
@@ -103,25 +185,117 @@ func (m *Movie) FromUpdateMovie(input UpdateMovie) {
 }
 
 // Create will insert a new movie in the database.
-func (r MovieRepository) Create(movie *Movie) error {
+func (r MovieRepository) Create(ctx context.Context, movie *Movie) error {
 	args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	return r.DB.QueryRow(ctx, createMovieSQL, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := r.DB.QueryRow(ctx, createMovieSQL, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return queryError(err)
+}
+
+// CreateWithID will insert a new movie with the ID chosen by the client in the database.
+// The sequence of the IDs is moved past the ID, so that the next movies created don't
+// collide with it. The table is locked against the concurrent inserts meanwhile, so
+// none of them draws the ID from the sequence before it's moved. If the ID is taken,
+// even by a soft deleted movie, ErrDuplicateMovie is returned.
+func (r MovieRepository) CreateWithID(ctx context.Context, movie *Movie) error {
+	if movie.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	args := []any{movie.ID, movie.Title, movie.Year, movie.Runtime, movie.Genres}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, lockMovieIDsSQL)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, advanceMovieIDSQL, movie.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, createMovieWithIDSQL, args...).Scan(&movie.CreatedAt, &movie.Version)
+		if err != nil {
+			var pgError *pgconn.PgError
+			if errors.As(err, &pgError) && pgError.Code == database.UniqueViolation {
+				return ErrDuplicateMovie
+			}
+			return err
+		}
+
+		return nil
+	})
+
+	return queryError(err)
+}
+
+// CreateMany will insert the movies in the database with a single COPY, which is
+// much faster than inserting them one by one. The IDs of the movies are drawn from
+// their sequence beforehand, since a COPY can't return them.
+func (r MovieRepository) CreateMany(ctx context.Context, movies []*Movie) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	ids, err := r.DB.Query(ctx, nextMovieIDsSQL, len(movies))
+	if err != nil {
+		return queryError(err)
+	}
+
+	i := 0
+	for ids.Next() {
+		err := ids.Scan(&movies[i].ID)
+		if err != nil {
+			ids.Close()
+			return queryError(err)
+		}
+		i++
+	}
+	ids.Close()
+	if err := ids.Err(); err != nil {
+		return queryError(err)
+	}
+
+	createdAt := time.Now()
+
+	rows := make([][]any, len(movies))
+	for i, movie := range movies {
+		movie.CreatedAt = createdAt
+		movie.Version = 1
+		rows[i] = []any{movie.ID, movie.CreatedAt, movie.Title, movie.Year, movie.Runtime, movie.Genres}
+	}
+
+	columns := []string{"id", "created_at", "title", "year", "runtime", "genres"}
+	_, err = r.DB.CopyFrom(ctx, pgx.Identifier{"movies"}, columns, pgx.CopyFromRows(rows))
+	return queryError(err)
 }
 
 // Read will fetch a movie from the database.
-func (r MovieRepository) Read(id int64) (*Movie, error) {
+// The soft deleted movies are not found.
+func (r MovieRepository) Read(ctx context.Context, id int64) (*Movie, error) {
+	return r.read(ctx, id, false)
+}
+
+// ReadIncludingDeleted will fetch a movie from the database, even if it is soft deleted.
+func (r MovieRepository) ReadIncludingDeleted(ctx context.Context, id int64) (*Movie, error) {
+	return r.read(ctx, id, true)
+}
+
+func (r MovieRepository) read(ctx context.Context, id int64, includeDeleted bool) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	var movie Movie
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
-	err := r.DB.QueryRow(ctx, readMovieSQL, id).Scan(
+	err := r.DB.QueryRow(ctx, readMovieSQL, id, includeDeleted).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -129,13 +303,16 @@ func (r MovieRepository) Read(id int64) (*Movie, error) {
 		&movie.Runtime,
 		&movie.Genres,
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.ReviewCount,
+		&movie.DeletedAt,
 	)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(err)
 		}
 	}
 
@@ -144,7 +321,7 @@ func (r MovieRepository) Read(id int64) (*Movie, error) {
 
 // Update will update a movie from the database.
 // This operation is implementing optimistic locking.
-func (r MovieRepository) Update(movie *Movie) error {
+func (r MovieRepository) Update(ctx context.Context, movie *Movie) error {
 	args := []any{
 		movie.Title,
 		movie.Year,
@@ -154,7 +331,7 @@ func (r MovieRepository) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 	err := r.DB.QueryRow(ctx, updateMovieSQL, args...).Scan(&movie.Version)
 	if err != nil {
@@ -162,51 +339,93 @@ func (r MovieRepository) Update(movie *Movie) error {
 		case errors.Is(err, pgx.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return queryError(err)
 		}
 	}
 
 	return nil
 }
 
-// Delete will delete a movie from the database.
-func (r MovieRepository) Delete(id int64) error {
+// Delete will soft delete a movie from the database. The movie is kept, marked as
+// deleted, until it is restored or purged.
+// When version isn't 0, this operation is implementing optimistic locking:
+// the movie is only deleted if it is still at that version.
+func (r MovieRepository) Delete(ctx context.Context, id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
-	result, err := r.DB.Exec(ctx, deleteMovieSQL, id)
+	result, err := r.DB.Exec(ctx, deleteMovieSQL, id, version)
 	if err != nil {
-		return err
+		return queryError(err)
 	}
 
 	if result.RowsAffected() == 0 {
+		if version != 0 {
+			return ErrEditConflict
+		}
 		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
+// Restore will restore a soft deleted movie.
+func (r MovieRepository) Restore(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	result, err := r.DB.Exec(ctx, restoreMovieSQL, id)
+	if err != nil {
+		return queryError(err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Purge will permanently delete the movies soft deleted before the given time,
+// and returns how many were deleted.
+func (r MovieRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	result, err := r.DB.Exec(ctx, purgeMoviesSQL, before)
+	if err != nil {
+		return 0, queryError(err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
 // ReadAll will fetch all movies based on the provided parameters.
-// It uses a full-text search for the title.
-func (r MovieRepository) ReadAll(title string, genres []string, filters database.Filters) ([]*Movie, database.Metadata, error) {
+// It uses a full-text search for the title and the names of the people.
+func (r MovieRepository) ReadAll(ctx context.Context, search MovieSearch, filters database.Filters) ([]*Movie, database.Metadata, error) {
+	if filters.Keyset {
+		return r.readAllKeyset(ctx, search, filters)
+	}
+
 	query := fmt.Sprintf(`
-        SELECT  count(*) OVER(), id, created_at, title, year, runtime, genres, version
+        SELECT  count(*) OVER(), id, created_at, title, year, runtime, genres, version, average_rating, review_count, deleted_at
         FROM movies
-        WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-        AND (genres @> $2 OR $2 = '{}')     
+        WHERE %s
         ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.SortColumn(), filters.SortDirection())
+		LIMIT $6 OFFSET $7`, movieSearchConditions, filters.SortColumn(), filters.SortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	args := []any{title, genres, filters.Limit(), filters.Offset()}
+	args := append(search.args(), filters.Limit(), filters.Offset())
 	rows, err := r.DB.Query(ctx, query, args...)
 	if err != nil {
-		return nil, database.Metadata{}, err
+		return nil, database.Metadata{}, queryError(err)
 	}
 	defer rows.Close()
 
@@ -224,19 +443,252 @@ func (r MovieRepository) ReadAll(title string, genres []string, filters database
 			&movie.Runtime,
 			&movie.Genres,
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.DeletedAt,
 		)
 		if err != nil {
-			return nil, database.Metadata{}, err
+			return nil, database.Metadata{}, queryError(err)
 		}
 
 		movies = append(movies, &movie)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, database.Metadata{}, err
+		return nil, database.Metadata{}, queryError(err)
 	}
 
 	metadata := database.NewMetadata(totalRecords, filters.Page, filters.PageSize)
 
 	return movies, metadata, err
 }
+
+// readAllKeyset will fetch a page of movies after (or before) the cursor of the filters.
+// Unlike the OFFSET based pagination, the cost of a page doesn't depend on its depth and the
+// pages don't shift when movies are added or deleted.
+func (r MovieRepository) readAllKeyset(ctx context.Context, search MovieSearch, filters database.Filters) ([]*Movie, database.Metadata, error) {
+	column := filters.SortColumn()
+	columnOp, idOp, columnDirection, idDirection := filters.KeysetOperators()
+
+	// One more movie than the page size is fetched to find out if there is a next page.
+	args := append(search.args(), filters.Limit()+1)
+
+	keyset := ""
+	if filters.Cursor != nil {
+		value, err := parseMovieSortValue(column, filters.Cursor.Value)
+		if err != nil {
+			return nil, database.Metadata{}, err
+		}
+
+		if column == "id" {
+			keyset = fmt.Sprintf("AND id %s $7", columnOp)
+			args = append(args, value)
+		} else {
+			keyset = fmt.Sprintf("AND (%[1]s %[2]s $7 OR (%[1]s = $7 AND id %[3]s $8))", column, columnOp, idOp)
+			args = append(args, value, filters.Cursor.ID)
+		}
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, created_at, title, year, runtime, genres, version, average_rating, review_count, deleted_at
+        FROM movies
+        WHERE %s
+        %s
+        ORDER BY %s %s, id %s
+		LIMIT $6`, movieSearchConditions, keyset, column, columnDirection, idDirection)
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, database.Metadata{}, queryError(err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+
+	movies, metadata := keysetPage(movies, column, filters)
+
+	return movies, metadata, nil
+}
+
+// ReadEach calls fn for every movie matching the search, in the order of their IDs and
+// up to limit movies. The movies are fetched in chunks through a server-side cursor, so
+// the memory use doesn't depend on the number of movies. The time budget of a query
+// applies to every chunk, not to the whole operation.
+func (r MovieRepository) ReadEach(ctx context.Context, search MovieSearch, limit int, fn func(*Movie) error) error {
+	query := fmt.Sprintf(`
+        DECLARE movies_each NO SCROLL CURSOR FOR
+        SELECT id, created_at, title, year, runtime, genres, version, average_rating, review_count, deleted_at
+        FROM movies
+        WHERE %s
+        ORDER BY id ASC
+        LIMIT $6`, movieSearchConditions)
+
+	beginCtx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	// A cursor only lives inside a transaction.
+	tx, err := r.DB.Begin(beginCtx)
+	if err != nil {
+		return queryError(err)
+	}
+	// The rollback must run even if ctx is already canceled; it closes the cursor too.
+	defer tx.Rollback(context.Background())
+
+	declareCtx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err = tx.Exec(declareCtx, query, append(search.args(), limit)...)
+	if err != nil {
+		return queryError(err)
+	}
+
+	const chunkSize = 500
+	for {
+		movies, err := fetchMovies(ctx, tx, r.Timeout, fmt.Sprintf("FETCH %d FROM movies_each", chunkSize))
+		if err != nil {
+			return err
+		}
+
+		// The chunk is fetched before fn is called, so a slow fn doesn't count
+		// towards the time budget of the query.
+		for _, movie := range movies {
+			err := fn(movie)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(movies) < chunkSize {
+			return nil
+		}
+	}
+}
+
+// fetchMovies runs a FETCH query of a movies cursor, within the time budget of a query.
+func fetchMovies(ctx context.Context, tx pgx.Tx, timeout time.Duration, query string) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, queryError(err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, queryError(err)
+	}
+
+	return movies, nil
+}
+
+// keysetPage trims the movies fetched with the keyset pagination to the page size,
+// puts them in the sort order and creates the metadata with the page cursors.
+func keysetPage(movies []*Movie, column string, filters database.Filters) ([]*Movie, database.Metadata) {
+	hasMore := len(movies) > filters.Limit()
+	if hasMore {
+		movies = movies[:filters.Limit()]
+	}
+
+	if filters.Cursor != nil && filters.Cursor.Backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	metadata := database.NewKeysetMetadata(filters, len(movies), hasMore, func(i int) database.Cursor {
+		return database.Cursor{Value: movies[i].sortValue(column), ID: movies[i].ID}
+	})
+
+	return movies, metadata
+}
+
+// sortValue returns the value of the sort column, as stored in a keyset pagination cursor.
+func (m Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return m.Title
+	case "year":
+		return strconv.FormatInt(int64(m.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(m.Runtime), 10)
+	case "average_rating":
+		return strconv.FormatFloat(m.AverageRating, 'g', -1, 64)
+	case "review_count":
+		return strconv.FormatInt(int64(m.ReviewCount), 10)
+	default:
+		return strconv.FormatInt(m.ID, 10)
+	}
+}
+
+// parseMovieSortValue converts the value of a keyset pagination cursor
+// to the type of the sort column.
+func parseMovieSortValue(column, value string) (any, error) {
+	switch column {
+	case "title":
+		return value, nil
+	case "average_rating":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, database.ErrInvalidCursor
+		}
+		return f, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, database.ErrInvalidCursor
+	}
+	return i, nil
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mroobert/json-api/internal/database"
+)
+
+//go:embed queries/outbox/create.sql
+var createOutboxEmailSQL string
+
+//go:embed queries/outbox/read_next.sql
+var readNextOutboxEmailSQL string
+
+//go:embed queries/outbox/update.sql
+var updateOutboxEmailSQL string
+
+// maxOutboxBackoff caps the delay between two attempts of sending an email. The data of
+// the pending emails holds plaintext tokens, so they shouldn't wait for long.
+const maxOutboxBackoff = time.Hour
+
+// Statuses of an email from the outbox.
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
+
+type (
+	// OutboxEmail represents an email waiting in the outbox to be sent.
+	OutboxEmail struct {
+		ID        int64
+		CreatedAt time.Time
+		Recipient string
+		Template  string         // Name of the mailer template file
+		Data      map[string]any // Dynamic data for the template
+		Status    string
+		Attempts  int
+		LastError string
+		RequestID string // ID of the request which queued the email, for the log entries
+	}
+
+	// OutboxRepository manages the set of APIs for email outbox database access.
+	OutboxRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+// Create will insert a new email in the outbox.
+// To guarantee that the email is sent only when the related changes are persisted,
+// it should be called from inside the same transaction as those changes.
+func (r OutboxRepository) Create(ctx context.Context, email *OutboxEmail) error {
+	data, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	args := []any{email.Recipient, email.Template, data, email.RequestID}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err = r.DB.QueryRow(ctx, createOutboxEmailSQL, args...).Scan(&email.ID, &email.CreatedAt, &email.Status)
+	return queryError(err)
+}
+
+// ProcessNext locks the next due email from the outbox (skipping the emails locked by
+// other workers) and calls send for it. The outcome is recorded in the same transaction:
+// on success the email is marked as sent, otherwise the attempt and the error are stored and
+// the email is rescheduled with an exponential backoff starting at baseBackoff. After
+// maxAttempts failed attempts the email is dead-lettered and no longer retried.
+// The data of the sent and dead-lettered emails is cleared, since it holds plaintext tokens.
+//
+// It returns the processed email, or nil if there was no email due.
+func (r OutboxRepository) ProcessNext(ctx context.Context, maxAttempts int, baseBackoff time.Duration, send func(*OutboxEmail) error) (*OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		email OutboxEmail
+		data  []byte
+	)
+	err = tx.QueryRow(ctx, readNextOutboxEmailSQL).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Recipient,
+		&email.Template,
+		&data,
+		&email.Status,
+		&email.Attempts,
+		&email.LastError,
+		&email.RequestID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	// Decode numbers as json.Number so they are rendered in the templates
+	// exactly as they were stored (e.g. user IDs without an exponent).
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err = dec.Decode(&email.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		nextAttemptAt = time.Now()
+		sentAt        *time.Time
+	)
+
+	email.Attempts++
+	err = send(&email)
+	switch {
+	case err == nil:
+		email.Status = OutboxStatusSent
+		email.LastError = ""
+		sentAt = &nextAttemptAt
+	case email.Attempts >= maxAttempts:
+		email.Status = OutboxStatusDead
+		email.LastError = err.Error()
+	default:
+		email.LastError = err.Error()
+		backoff := baseBackoff << (email.Attempts - 1)
+		if backoff <= 0 || backoff > maxOutboxBackoff {
+			backoff = maxOutboxBackoff
+		}
+		nextAttemptAt = nextAttemptAt.Add(backoff)
+	}
+
+	args := []any{email.Status, email.Attempts, email.LastError, nextAttemptAt, sentAt, email.ID}
+	_, err = tx.Exec(ctx, updateOutboxEmailSQL, args...)
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	return &email, queryError(tx.Commit(ctx))
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+)
+
+//go:embed queries/people/create.sql
+var createPersonSQL string
+
+//go:embed queries/people/read.sql
+var readPersonSQL string
+
+//go:embed queries/people/update.sql
+var updatePersonSQL string
+
+//go:embed queries/people/delete.sql
+var deletePersonSQL string
+
+//go:embed queries/people/touch_movies.sql
+var touchPersonMoviesSQL string
+
+type (
+	// Person represents someone credited in movies, as actor, director or writer.
+	Person struct {
+		ID        int64     `json:"id"`
+		CreatedAt time.Time `json:"-"`
+		Name      string    `json:"name"`
+		BirthYear int32     `json:"birth_year,omitempty"`
+		Biography string    `json:"biography,omitempty"`
+		Version   int32     `json:"version"`
+	}
+
+	// NewPerson contains information needed to create a new person.
+	NewPerson struct {
+		Name      string `json:"name"`
+		BirthYear int32  `json:"birth_year"`
+		Biography string `json:"biography"`
+	}
+
+	// UpdatePerson contains information needed to update a Person.
+	// All fields are optional so clients can send just the fields they want changed.
+	UpdatePerson struct {
+		Name      *string `json:"name"`
+		BirthYear *int32  `json:"birth_year"`
+		Biography *string `json:"biography"`
+	}
+
+	// PersonRepository manages the set of APIs for person database access.
+	PersonRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+func (p Person) Validate(vld *validator.Validator) {
+	vld.Check(p.Name != "", "name", "must be provided")
+	vld.Check(len(p.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	// The birth year is optional.
+	if p.BirthYear != 0 {
+		vld.Check(p.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		vld.Check(p.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+
+	vld.Check(len(p.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+func (p *Person) FromNewPerson(input NewPerson) {
+	p.Name = input.Name
+	p.BirthYear = input.BirthYear
+	p.Biography = input.Biography
+}
+
+func (p *Person) FromUpdatePerson(input UpdatePerson) {
+	if input.Name != nil {
+		p.Name = *input.Name
+	}
+	if input.BirthYear != nil {
+		p.BirthYear = *input.BirthYear
+	}
+	if input.Biography != nil {
+		p.Biography = *input.Biography
+	}
+}
+
+// Create will insert a new person in the database.
+func (r PersonRepository) Create(ctx context.Context, person *Person) error {
+	args := []any{person.Name, person.BirthYear, person.Biography}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, createPersonSQL, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+	return queryError(err)
+}
+
+// Read will fetch a person from the database.
+func (r PersonRepository) Read(ctx context.Context, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, readPersonSQL, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	return &person, nil
+}
+
+// Update will update a person from the database.
+// This operation is implementing optimistic locking.
+// The name of the person is part of the credits of the movies, so the version
+// of every movie crediting the person is incremented in the same transaction.
+func (r PersonRepository) Update(ctx context.Context, person *Person) error {
+	args := []any{person.Name, person.BirthYear, person.Biography, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, updatePersonSQL, args...).Scan(&person.Version)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrEditConflict
+			}
+			return err
+		}
+
+		_, err = tx.Exec(ctx, touchPersonMoviesSQL, person.ID)
+		return err
+	})
+
+	return queryError(err)
+}
+
+// Delete will delete a person, together with the credits of the person, from the database.
+// The version of every movie crediting the person is incremented in the same transaction.
+func (r PersonRepository) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		// The movies are touched first, since deleting the person deletes the credits.
+		_, err := tx.Exec(ctx, touchPersonMoviesSQL, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx, deletePersonSQL, id)
+		if err != nil {
+			return err
+		}
+
+		if result.RowsAffected() == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+
+	return queryError(err)
+}
+
+// ReadAll will fetch all people based on the provided parameters.
+// It uses a full-text search for the name.
+func (r PersonRepository) ReadAll(ctx context.Context, name string, filters database.Filters) ([]*Person, database.Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, name, birth_year, biography, version
+        FROM people
+        WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	args := []any{name, filters.Limit(), filters.Offset()}
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, database.Metadata{}, queryError(err)
+		}
+
+		people = append(people, &person)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+
+	metadata := database.NewMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"time"
+
+	"github.com/mroobert/json-api/internal/database"
+)
+
+//go:embed queries/permissions/read_all_for_user.sql
+var readAllPermissionsForUserSQL string
+
+//go:embed queries/permissions/add_for_user.sql
+var addPermissionsForUserSQL string
+
+// Permission codes used to authorize access to the endpoints.
+const (
+	PermissionMoviesRead  = "movies:read"
+	PermissionMoviesWrite = "movies:write"
+)
+
+type (
+	// Permissions holds the permission codes (like "movies:read" and "movies:write")
+	// for a single user.
+	Permissions []string
+
+	// PermissionRepository manages the set of APIs for permission database access.
+	PermissionRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+// Include checks whether the Permissions slice contains a specific permission code.
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllForUser will fetch all permission codes for a specific user.
+func (r PermissionRepository) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.Query(ctx, readAllPermissionsForUserSQL, userID)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, queryError(err)
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, queryError(err)
+	}
+
+	return permissions, nil
+}
+
+// AddForUser will grant the provided permission codes to a specific user.
+func (r PermissionRepository) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.Exec(ctx, addPermissionsForUserSQL, userID, codes)
+	return queryError(err)
+}
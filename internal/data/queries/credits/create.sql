@@ -0,0 +1,3 @@
+INSERT INTO movie_credits (movie_id, person_id, role, character_name, billing_order)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
\ No newline at end of file
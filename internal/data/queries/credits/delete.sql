@@ -0,0 +1,2 @@
+DELETE FROM movie_credits
+WHERE id = $1 AND movie_id = $2
\ No newline at end of file
@@ -0,0 +1,6 @@
+SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+    movie_credits.role, movie_credits.character_name, movie_credits.billing_order
+FROM movie_credits
+INNER JOIN people ON people.id = movie_credits.person_id
+WHERE movie_credits.movie_id = $1
+ORDER BY movie_credits.billing_order ASC, movie_credits.id ASC
\ No newline at end of file
@@ -0,0 +1,3 @@
+UPDATE movies
+SET version = version + 1
+WHERE id = $1
\ No newline at end of file
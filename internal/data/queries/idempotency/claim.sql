@@ -0,0 +1,9 @@
+INSERT INTO idempotency_keys (key, route, fingerprint, expires_at, locked_until)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (key, route) DO UPDATE
+SET created_at = NOW(), expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until,
+    fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL
+WHERE idempotency_keys.expires_at <= NOW()
+    OR (idempotency_keys.status IS NULL AND idempotency_keys.locked_until <= NOW()
+        AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+RETURNING created_at
\ No newline at end of file
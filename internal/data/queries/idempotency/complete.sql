@@ -0,0 +1,3 @@
+UPDATE idempotency_keys
+SET status = $3, headers = $4, body = $5
+WHERE key = $1 AND route = $2 AND status IS NULL
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT created_at, expires_at, fingerprint, status, headers, body
+FROM idempotency_keys
+WHERE key = $1 AND route = $2
\ No newline at end of file
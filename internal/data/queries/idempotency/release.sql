@@ -0,0 +1,2 @@
+DELETE FROM idempotency_keys
+WHERE key = $1 AND route = $2 AND status IS NULL
\ No newline at end of file
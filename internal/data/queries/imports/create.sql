@@ -0,0 +1,3 @@
+INSERT INTO import_jobs (user_id, format, owner, locked_until)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, updated_at, status
\ No newline at end of file
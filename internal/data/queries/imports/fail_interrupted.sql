@@ -0,0 +1,3 @@
+UPDATE import_jobs
+SET status = 'failed', error = $1, updated_at = NOW()
+WHERE status IN ('pending', 'running') AND locked_until <= NOW()
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT id, created_at, updated_at, user_id, format, status, accepted, rejected, report, error
+FROM import_jobs
+WHERE id = $1
\ No newline at end of file
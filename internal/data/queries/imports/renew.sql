@@ -0,0 +1,3 @@
+UPDATE import_jobs
+SET locked_until = $3
+WHERE id = $1 AND owner = $2 AND status IN ('pending', 'running')
\ No newline at end of file
@@ -0,0 +1,4 @@
+UPDATE import_jobs
+SET status = 'running', updated_at = NOW()
+WHERE id = $1 AND status = 'pending'
+RETURNING updated_at
\ No newline at end of file
@@ -0,0 +1,4 @@
+UPDATE import_jobs
+SET status = $1, accepted = $2, rejected = $3, report = $4, error = $5, updated_at = NOW()
+WHERE id = $6 AND status = 'running'
+RETURNING updated_at
\ No newline at end of file
@@ -0,0 +1,2 @@
+SELECT setval(pg_get_serial_sequence('movies', 'id'), $1)
+WHERE $1 > COALESCE(pg_sequence_last_value(pg_get_serial_sequence('movies', 'id')::regclass), 0)
\ No newline at end of file
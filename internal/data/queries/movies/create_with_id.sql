@@ -0,0 +1,3 @@
+INSERT INTO movies (id, title, year, runtime, genres)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING created_at, version
\ No newline at end of file
@@ -1,2 +1,3 @@
-DELETE FROM movies
-WHERE id = $1
\ No newline at end of file
+UPDATE movies
+SET deleted_at = NOW(), version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
\ No newline at end of file
@@ -0,0 +1 @@
+LOCK TABLE movies IN SHARE ROW EXCLUSIVE MODE
\ No newline at end of file
@@ -0,0 +1,2 @@
+SELECT nextval(pg_get_serial_sequence('movies', 'id'))
+FROM generate_series(1, $1)
\ No newline at end of file
@@ -0,0 +1,2 @@
+DELETE FROM movies
+WHERE deleted_at < $1
\ No newline at end of file
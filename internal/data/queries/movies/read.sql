@@ -1,3 +1,3 @@
-SELECT id, created_at, title, year, runtime, genres, version
+SELECT id, created_at, title, year, runtime, genres, version, average_rating, review_count, deleted_at
 FROM movies
-WHERE id = $1
\ No newline at end of file
+WHERE id = $1 AND (deleted_at IS NULL OR $2)
\ No newline at end of file
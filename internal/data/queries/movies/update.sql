@@ -1,4 +1,4 @@
 UPDATE movies 
 SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-WHERE id = $5 AND version = $6
+WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 RETURNING version
\ No newline at end of file
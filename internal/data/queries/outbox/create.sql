@@ -0,0 +1,3 @@
+INSERT INTO email_outbox (recipient, template, data, request_id)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, status
\ No newline at end of file
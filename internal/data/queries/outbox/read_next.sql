@@ -0,0 +1,6 @@
+SELECT id, created_at, recipient, template, data, status, attempts, last_error, request_id
+FROM email_outbox
+WHERE status = 'pending' AND next_attempt_at <= NOW()
+ORDER BY next_attempt_at, id
+LIMIT 1
+FOR UPDATE SKIP LOCKED
\ No newline at end of file
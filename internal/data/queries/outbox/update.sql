@@ -0,0 +1,4 @@
+UPDATE email_outbox
+SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, sent_at = $5,
+    data = CASE WHEN $1 = 'pending' THEN data END
+WHERE id = $6
\ No newline at end of file
@@ -0,0 +1,3 @@
+INSERT INTO people (name, birth_year, biography)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, version
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT id, created_at, name, birth_year, biography, version
+FROM people
+WHERE id = $1
\ No newline at end of file
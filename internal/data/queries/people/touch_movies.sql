@@ -0,0 +1,3 @@
+UPDATE movies
+SET version = version + 1
+WHERE id IN (SELECT movie_id FROM movie_credits WHERE person_id = $1)
\ No newline at end of file
@@ -0,0 +1,4 @@
+UPDATE people
+SET name = $1, birth_year = $2, biography = $3, version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING version
\ No newline at end of file
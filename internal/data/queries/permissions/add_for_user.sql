@@ -0,0 +1,2 @@
+INSERT INTO users_permissions
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
\ No newline at end of file
@@ -0,0 +1,5 @@
+INSERT INTO reviews (user_id, movie_id, rating, body)
+SELECT $1, id, $3, $4
+FROM movies
+WHERE id = $2 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, version
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM reviews
+WHERE id = $1
+RETURNING movie_id
\ No newline at end of file
@@ -0,0 +1,4 @@
+SELECT 1
+FROM movies
+WHERE id = $1
+FOR NO KEY UPDATE
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT id, created_at, updated_at, user_id, movie_id, rating, body, version
+FROM reviews
+WHERE id = $1
\ No newline at end of file
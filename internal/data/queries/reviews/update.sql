@@ -0,0 +1,4 @@
+UPDATE reviews
+SET rating = $1, body = $2, updated_at = NOW(), version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING updated_at, version
\ No newline at end of file
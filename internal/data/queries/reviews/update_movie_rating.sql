@@ -0,0 +1,8 @@
+UPDATE movies
+SET average_rating = aggregates.average_rating, review_count = aggregates.review_count
+FROM (
+    SELECT COALESCE(AVG(rating), 0) AS average_rating, count(*) AS review_count
+    FROM reviews
+    WHERE movie_id = $1
+) AS aggregates
+WHERE id = $1
\ No newline at end of file
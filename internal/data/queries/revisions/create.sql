@@ -0,0 +1,3 @@
+INSERT INTO movie_revisions (movie_id, version, operation, snapshot, changes, changed_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING created_at
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT movie_id, version, created_at, operation, snapshot, changes, changed_by
+FROM movie_revisions
+WHERE movie_id = $1 AND version = $2
\ No newline at end of file
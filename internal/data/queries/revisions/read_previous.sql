@@ -0,0 +1,5 @@
+SELECT snapshot
+FROM movie_revisions
+WHERE movie_id = $1 AND version < $2
+ORDER BY version DESC
+LIMIT 1
\ No newline at end of file
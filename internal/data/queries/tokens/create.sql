@@ -0,0 +1,2 @@
+INSERT INTO tokens (hash, user_id, expiry, scope)
+VALUES ($1, $2, $3, $4)
\ No newline at end of file
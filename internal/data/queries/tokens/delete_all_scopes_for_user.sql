@@ -0,0 +1,2 @@
+DELETE FROM tokens
+WHERE user_id = $1
\ No newline at end of file
@@ -0,0 +1,3 @@
+INSERT INTO watchlist_items (watchlist_id, movie_id, position)
+VALUES ($1, $2, $3)
+RETURNING added_at
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT count(*)
+FROM watchlist_items
+WHERE watchlist_id = $1
\ No newline at end of file
@@ -0,0 +1,3 @@
+INSERT INTO watchlists (user_id, name)
+VALUES ($1, $2)
+RETURNING id, created_at, updated_at, version
\ No newline at end of file
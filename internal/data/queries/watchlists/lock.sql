@@ -0,0 +1,4 @@
+SELECT 1
+FROM watchlists
+WHERE id = $1
+FOR UPDATE
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT id, created_at, updated_at, user_id, name, version
+FROM watchlists
+WHERE id = $1
\ No newline at end of file
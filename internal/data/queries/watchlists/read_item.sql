@@ -0,0 +1,3 @@
+SELECT watchlist_id, movie_id, position, added_at, watched_at
+FROM watchlist_items
+WHERE watchlist_id = $1 AND movie_id = $2
\ No newline at end of file
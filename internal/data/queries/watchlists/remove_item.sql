@@ -0,0 +1,3 @@
+DELETE FROM watchlist_items
+WHERE watchlist_id = $1 AND movie_id = $2
+RETURNING position
\ No newline at end of file
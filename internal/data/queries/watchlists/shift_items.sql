@@ -0,0 +1,3 @@
+UPDATE watchlist_items
+SET position = position + $4
+WHERE watchlist_id = $1 AND position BETWEEN $2 AND $3
\ No newline at end of file
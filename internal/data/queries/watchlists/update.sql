@@ -0,0 +1,4 @@
+UPDATE watchlists
+SET name = $1, updated_at = NOW(), version = version + 1
+WHERE id = $2 AND version = $3
+RETURNING updated_at, version
\ No newline at end of file
@@ -0,0 +1,3 @@
+UPDATE watchlist_items
+SET position = $3, watched_at = $4
+WHERE watchlist_id = $1 AND movie_id = $2
\ No newline at end of file
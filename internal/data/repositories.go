@@ -1,26 +1,280 @@
 package data
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mroobert/json-api/internal/database"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrQueryCanceled  = errors.New("query canceled")
+	ErrQueryTimeout   = errors.New("query timeout")
 )
 
-// Repositories will represent a convenient single 'container' which
-// can hold and represent the set of APIs for database access.
-type Repositories struct {
-	Movies MovieRepository
-	Users  UserRepository
+// Compile-time checks that the stores satisfy their interfaces.
+var (
+	_ CreditStore         = CreditRepository{}
+	_ CreditStore         = (*MemoryCreditStore)(nil)
+	_ IdempotencyKeyStore = IdempotencyKeyRepository{}
+	_ IdempotencyKeyStore = (*MemoryIdempotencyKeyStore)(nil)
+	_ ImportJobStore      = ImportJobRepository{}
+	_ ImportJobStore      = (*MemoryImportJobStore)(nil)
+	_ MovieStore          = MovieRepository{}
+	_ MovieStore          = (*MemoryMovieStore)(nil)
+	_ MovieRevisionStore  = MovieRevisionRepository{}
+	_ MovieRevisionStore  = (*MemoryMovieRevisionStore)(nil)
+	_ OutboxStore         = OutboxRepository{}
+	_ OutboxStore         = (*MemoryOutboxStore)(nil)
+	_ PermissionStore     = PermissionRepository{}
+	_ PermissionStore     = (*MemoryPermissionStore)(nil)
+	_ PersonStore         = PersonRepository{}
+	_ PersonStore         = (*MemoryPersonStore)(nil)
+	_ ReviewStore         = ReviewRepository{}
+	_ ReviewStore         = (*MemoryReviewStore)(nil)
+	_ TokenStore          = TokenRepository{}
+	_ TokenStore          = (*MemoryTokenStore)(nil)
+	_ UserStore           = UserRepository{}
+	_ UserStore           = (*MemoryUserStore)(nil)
+	_ WatchlistStore      = WatchlistRepository{}
+	_ WatchlistStore      = (*MemoryWatchlistStore)(nil)
+)
+
+type (
+	// CreditStore is the set of APIs for credit storage access.
+	// It is implemented by CreditRepository and MemoryCreditStore.
+	CreditStore interface {
+		Create(ctx context.Context, credit *Credit) error
+		Delete(ctx context.Context, movieID, id int64) error
+		ReadAllForMovie(ctx context.Context, movieID int64) ([]*Credit, error)
+	}
+
+	// IdempotencyKeyStore is the set of APIs for idempotency key storage access.
+	// It is implemented by IdempotencyKeyRepository and MemoryIdempotencyKeyStore.
+	IdempotencyKeyStore interface {
+		Claim(ctx context.Context, key *IdempotencyKey) (bool, error)
+		Complete(ctx context.Context, key *IdempotencyKey) error
+		Release(ctx context.Context, key *IdempotencyKey) error
+		DeleteExpired(ctx context.Context) (int64, error)
+	}
+
+	// ImportJobStore is the set of APIs for import job storage access.
+	// It is implemented by ImportJobRepository and MemoryImportJobStore.
+	ImportJobStore interface {
+		Create(ctx context.Context, job *ImportJob) error
+		Read(ctx context.Context, id int64) (*ImportJob, error)
+		Start(ctx context.Context, job *ImportJob) error
+		Update(ctx context.Context, job *ImportJob) error
+		Renew(ctx context.Context, id int64, owner string, lockedUntil time.Time) error
+		FailInterrupted(ctx context.Context, message string) (int64, error)
+	}
+
+	// MovieStore is the set of APIs for movie storage access.
+	// It is implemented by MovieRepository and MemoryMovieStore.
+	MovieStore interface {
+		Create(ctx context.Context, movie *Movie) error
+		CreateWithID(ctx context.Context, movie *Movie) error
+		CreateMany(ctx context.Context, movies []*Movie) error
+		Read(ctx context.Context, id int64) (*Movie, error)
+		ReadIncludingDeleted(ctx context.Context, id int64) (*Movie, error)
+		Update(ctx context.Context, movie *Movie) error
+		Delete(ctx context.Context, id int64, version int32) error
+		Restore(ctx context.Context, id int64) error
+		Purge(ctx context.Context, before time.Time) (int64, error)
+		ReadAll(ctx context.Context, search MovieSearch, filters database.Filters) ([]*Movie, database.Metadata, error)
+		ReadEach(ctx context.Context, search MovieSearch, limit int, fn func(*Movie) error) error
+	}
+
+	// MovieRevisionStore is the set of APIs for movie revision storage access.
+	// It is implemented by MovieRevisionRepository and MemoryMovieRevisionStore.
+	MovieRevisionStore interface {
+		Create(ctx context.Context, revision *MovieRevision) error
+		CreateMany(ctx context.Context, revisions []*MovieRevision) error
+		Read(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+		ReadAllForMovie(ctx context.Context, movieID int64, filters database.Filters) ([]*MovieRevision, database.Metadata, error)
+	}
+
+	// OutboxStore is the set of APIs for email outbox storage access.
+	// It is implemented by OutboxRepository and MemoryOutboxStore.
+	OutboxStore interface {
+		Create(ctx context.Context, email *OutboxEmail) error
+		ProcessNext(ctx context.Context, maxAttempts int, baseBackoff time.Duration, send func(*OutboxEmail) error) (*OutboxEmail, error)
+	}
+
+	// PermissionStore is the set of APIs for permission storage access.
+	// It is implemented by PermissionRepository and MemoryPermissionStore.
+	PermissionStore interface {
+		GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+		AddForUser(ctx context.Context, userID int64, codes ...string) error
+	}
+
+	// PersonStore is the set of APIs for person storage access.
+	// It is implemented by PersonRepository and MemoryPersonStore.
+	PersonStore interface {
+		Create(ctx context.Context, person *Person) error
+		Read(ctx context.Context, id int64) (*Person, error)
+		Update(ctx context.Context, person *Person) error
+		Delete(ctx context.Context, id int64) error
+		ReadAll(ctx context.Context, name string, filters database.Filters) ([]*Person, database.Metadata, error)
+	}
+
+	// ReviewStore is the set of APIs for review storage access.
+	// It is implemented by ReviewRepository and MemoryReviewStore.
+	ReviewStore interface {
+		Create(ctx context.Context, review *Review) error
+		Read(ctx context.Context, id int64) (*Review, error)
+		Update(ctx context.Context, review *Review) error
+		Delete(ctx context.Context, id int64) error
+		ReadAllForMovie(ctx context.Context, movieID int64, filters database.Filters) ([]*Review, database.Metadata, error)
+	}
+
+	// TokenStore is the set of APIs for token storage access.
+	// It is implemented by TokenRepository and MemoryTokenStore.
+	TokenStore interface {
+		New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+		DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+		DeleteAllScopesForUser(ctx context.Context, userID int64) error
+	}
+
+	// UserStore is the set of APIs for user storage access.
+	// It is implemented by UserRepository and MemoryUserStore.
+	UserStore interface {
+		Create(ctx context.Context, user *User) error
+		Read(ctx context.Context, email string) (*User, error)
+		ReadForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+		Update(ctx context.Context, user *User) error
+	}
+
+	// WatchlistStore is the set of APIs for watchlist storage access.
+	// It is implemented by WatchlistRepository and MemoryWatchlistStore.
+	WatchlistStore interface {
+		Create(ctx context.Context, watchlist *Watchlist) error
+		Read(ctx context.Context, id int64) (*Watchlist, error)
+		Update(ctx context.Context, watchlist *Watchlist) error
+		Delete(ctx context.Context, id int64) error
+		ReadAllForUser(ctx context.Context, userID int64, filters database.Filters) ([]*Watchlist, database.Metadata, error)
+		AddItem(ctx context.Context, item *WatchlistItem) error
+		ReadItem(ctx context.Context, watchlistID, movieID int64) (*WatchlistItem, error)
+		UpdateItem(ctx context.Context, item *WatchlistItem) error
+		RemoveItem(ctx context.Context, watchlistID, movieID int64) error
+		ReadAllItems(ctx context.Context, watchlistID int64, filters database.Filters) ([]*WatchlistItem, database.Metadata, error)
+	}
+
+	// Repositories will represent a convenient single 'container' which
+	// can hold and represent the set of APIs for database access.
+	Repositories struct {
+		Credits         CreditStore
+		IdempotencyKeys IdempotencyKeyStore
+		ImportJobs      ImportJobStore
+		MovieRevisions  MovieRevisionStore
+		Movies          MovieStore
+		Outbox          OutboxStore
+		People          PersonStore
+		Permissions     PermissionStore
+		Reviews         ReviewStore
+		Tokens          TokenStore
+		Users           UserStore
+		Watchlists      WatchlistStore
+
+		db      database.DBTX
+		timeout time.Duration
+	}
+)
+
+// NewRepositories creates the repositories backed by the database pool.
+// The queryTimeout is the time budget of every single query.
+func NewRepositories(db *pgxpool.Pool, queryTimeout time.Duration) Repositories {
+	return newRepositories(db, queryTimeout)
 }
 
-func NewRepositories(db *pgxpool.Pool) Repositories {
+func newRepositories(db database.DBTX, timeout time.Duration) Repositories {
 	return Repositories{
-		Movies: MovieRepository{DB: db},
-		Users:  UserRepository{DB: db},
+		Credits:         CreditRepository{DB: db, Timeout: timeout},
+		IdempotencyKeys: IdempotencyKeyRepository{DB: db, Timeout: timeout},
+		ImportJobs:      ImportJobRepository{DB: db, Timeout: timeout},
+		MovieRevisions:  MovieRevisionRepository{DB: db, Timeout: timeout},
+		Movies:          MovieRepository{DB: db, Timeout: timeout},
+		Outbox:          OutboxRepository{DB: db, Timeout: timeout},
+		People:          PersonRepository{DB: db, Timeout: timeout},
+		Permissions:     PermissionRepository{DB: db, Timeout: timeout},
+		Reviews:         ReviewRepository{DB: db, Timeout: timeout},
+		Tokens:          TokenRepository{DB: db, Timeout: timeout},
+		Users:           UserRepository{DB: db, Timeout: timeout},
+		Watchlists:      WatchlistRepository{DB: db, Timeout: timeout},
+		db:              db,
+		timeout:         timeout,
+	}
+}
+
+// NewMemoryRepositories creates repositories kept in memory, so the handlers
+// can be exercised without a database.
+func NewMemoryRepositories() Repositories {
+	movies := NewMemoryMovieStore()
+	people := NewMemoryPersonStore(movies)
+	users := NewMemoryUserStore()
+
+	return Repositories{
+		Credits:         NewMemoryCreditStore(people),
+		IdempotencyKeys: NewMemoryIdempotencyKeyStore(),
+		ImportJobs:      NewMemoryImportJobStore(),
+		MovieRevisions:  NewMemoryMovieRevisionStore(),
+		Movies:          movies,
+		Outbox:          NewMemoryOutboxStore(),
+		People:          people,
+		Permissions:     NewMemoryPermissionStore(),
+		Reviews:         NewMemoryReviewStore(movies),
+		Tokens:          NewMemoryTokenStore(users),
+		Users:           users,
+		Watchlists:      NewMemoryWatchlistStore(movies),
+	}
+}
+
+// Transaction runs fn with a copy of the repositories bound to a single database
+// transaction. The transaction is committed if fn returns nil and rolled back otherwise.
+// Repositories that are not backed by a database run fn without a transaction.
+func (r Repositories) Transaction(ctx context.Context, fn func(tx Repositories) error) error {
+	if r.db == nil {
+		return fn(r)
+	}
+
+	beginCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tx, err := r.db.Begin(beginCtx)
+	if err != nil {
+		return queryError(err)
+	}
+	// The rollback must run even if ctx is already canceled.
+	defer tx.Rollback(context.Background())
+
+	err = fn(newRepositories(tx, r.timeout))
+	if err != nil {
+		return err
+	}
+
+	commitCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return queryError(tx.Commit(commitCtx))
+}
+
+// queryError converts the context errors of a query into ErrQueryCanceled (the caller
+// gave up on the query) and ErrQueryTimeout (the query ran out of its time budget),
+// so they can be told apart from the other database errors.
+func queryError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %v", ErrQueryCanceled, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+	default:
+		return err
 	}
 }
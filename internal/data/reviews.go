@@ -0,0 +1,275 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+)
+
+//go:embed queries/reviews/create.sql
+var createReviewSQL string
+
+//go:embed queries/reviews/read.sql
+var readReviewSQL string
+
+//go:embed queries/reviews/update.sql
+var updateReviewSQL string
+
+//go:embed queries/reviews/delete.sql
+var deleteReviewSQL string
+
+//go:embed queries/reviews/update_movie_rating.sql
+var updateMovieRatingSQL string
+
+//go:embed queries/reviews/lock_movie.sql
+var lockReviewedMovieSQL string
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+type (
+	// Review represents the review of a movie by a user.
+	Review struct {
+		ID        int64     `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+		UserID    int64     `json:"user_id"`
+		MovieID   int64     `json:"movie_id"`
+		Rating    int32     `json:"rating"` // From 1 to 10
+		Body      string    `json:"body,omitempty"`
+		Version   int32     `json:"version"`
+	}
+
+	// NewReview contains information needed to create a new review.
+	NewReview struct {
+		Rating int32  `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	// UpdateReview contains information needed to update a Review.
+	// All fields are optional so clients can send just the fields they want changed.
+	UpdateReview struct {
+		Rating *int32  `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	// ReviewRepository manages the set of APIs for review database access.
+	// Every change of the reviews updates the average rating and the
+	// review count of the movie in the same transaction.
+	ReviewRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+func (rv Review) Validate(vld *validator.Validator) {
+	vld.Check(rv.Rating != 0, "rating", "must be provided")
+	vld.Check(rv.Rating >= 1 && rv.Rating <= 10, "rating", "must be between 1 and 10")
+
+	vld.Check(len(rv.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+func (rv *Review) FromNewReview(input NewReview) {
+	rv.Rating = input.Rating
+	rv.Body = input.Body
+}
+
+func (rv *Review) FromUpdateReview(input UpdateReview) {
+	if input.Rating != nil {
+		rv.Rating = *input.Rating
+	}
+	if input.Body != nil {
+		rv.Body = *input.Body
+	}
+}
+
+// Create will insert a new review in the database.
+// A user can review a movie only once, and can't review a soft deleted movie.
+func (r ReviewRepository) Create(ctx context.Context, review *Review) error {
+	args := []any{review.UserID, review.MovieID, review.Rating, review.Body}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, createReviewSQL, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+
+			var pgError *pgconn.PgError
+			if errors.As(err, &pgError) {
+				switch pgError.Code {
+				case database.UniqueViolation:
+					return ErrDuplicateReview
+				case database.ForeignKeyViolation:
+					return ErrRecordNotFound
+				}
+			}
+			return err
+		}
+
+		return updateMovieRating(ctx, tx, review.MovieID)
+	})
+
+	return queryError(err)
+}
+
+// Read will fetch a review from the database.
+func (r ReviewRepository) Read(ctx context.Context, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, readReviewSQL, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.UserID,
+		&review.MovieID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	return &review, nil
+}
+
+// Update will update a review from the database.
+// This operation is implementing optimistic locking.
+func (r ReviewRepository) Update(ctx context.Context, review *Review) error {
+	args := []any{review.Rating, review.Body, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, updateReviewSQL, args...).Scan(&review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return updateMovieRating(ctx, tx, review.MovieID)
+	})
+
+	return queryError(err)
+}
+
+// Delete will delete a review from the database.
+func (r ReviewRepository) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		var movieID int64
+		err := tx.QueryRow(ctx, deleteReviewSQL, id).Scan(&movieID)
+		if err != nil {
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		return updateMovieRating(ctx, tx, movieID)
+	})
+
+	return queryError(err)
+}
+
+// ReadAllForMovie will fetch the reviews of a movie based on the provided filters.
+func (r ReviewRepository) ReadAllForMovie(ctx context.Context, movieID int64, filters database.Filters) ([]*Review, database.Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, updated_at, user_id, movie_id, rating, body, version
+        FROM reviews
+        WHERE movie_id = $1
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	args := []any{movieID, filters.Limit(), filters.Offset()}
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.UserID,
+			&review.MovieID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, database.Metadata{}, queryError(err)
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+
+	metadata := database.NewMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// updateMovieRating recomputes the average rating and the review count of the movie.
+// The movie is locked first, in its own statement, so that the aggregates are computed
+// from a snapshot taken once the concurrent review writes on the movie are committed,
+// rather than overwriting them with stale values.
+func updateMovieRating(ctx context.Context, tx pgx.Tx, movieID int64) error {
+	_, err := tx.Exec(ctx, lockReviewedMovieSQL, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, updateMovieRatingSQL, movieID)
+	return err
+}
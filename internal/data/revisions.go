@@ -0,0 +1,313 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mroobert/json-api/internal/database"
+)
+
+//go:embed queries/revisions/create.sql
+var createRevisionSQL string
+
+//go:embed queries/revisions/read.sql
+var readRevisionSQL string
+
+//go:embed queries/revisions/read_previous.sql
+var readPreviousRevisionSQL string
+
+// Operations which create a revision of a movie.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+type (
+	// MovieRevision represents the state of a movie after one of its changes.
+	// The versions of the movie bumped by the changes of its credits have no revision.
+	MovieRevision struct {
+		MovieID   int64                  `json:"movie_id"`
+		Version   int32                  `json:"version"` // Version of the movie after the change
+		CreatedAt time.Time              `json:"created_at"`
+		Operation string                 `json:"operation"`
+		Snapshot  MovieSnapshot          `json:"snapshot"`
+		Changes   map[string]FieldChange `json:"changes"`    // Fields changed since the previous revision
+		ChangedBy *int64                 `json:"changed_by"` // ID of the user who made the change, if still known
+	}
+
+	// MovieSnapshot holds the fields of a movie at one of its revisions.
+	MovieSnapshot struct {
+		Title     string     `json:"title"`
+		Year      int32      `json:"year"`
+		Runtime   Runtime    `json:"runtime"`
+		Genres    []string   `json:"genres"`
+		DeletedAt *time.Time `json:"deleted_at"`
+	}
+
+	// FieldChange holds the previous and the new value of a changed field.
+	// The previous value is null for the fields of a new movie.
+	FieldChange struct {
+		From any `json:"from"`
+		To   any `json:"to"`
+	}
+
+	// MovieRevisionRepository manages the set of APIs for movie revision database access.
+	// The revisions must be created in the transaction of the change they record.
+	MovieRevisionRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+// NewMovieRevision creates the revision of the movie after a change made by a user.
+func NewMovieRevision(operation string, movie *Movie, changedBy int64) *MovieRevision {
+	return &MovieRevision{
+		MovieID:   movie.ID,
+		Version:   movie.Version,
+		Operation: operation,
+		Snapshot: MovieSnapshot{
+			Title:     movie.Title,
+			Year:      movie.Year,
+			Runtime:   movie.Runtime,
+			Genres:    movie.Genres,
+			DeletedAt: movie.DeletedAt,
+		},
+		ChangedBy: &changedBy,
+	}
+}
+
+// changes returns the fields of the snapshot which differ from the previous one,
+// or all of them when there is no previous snapshot.
+func (s MovieSnapshot) changes(previous *MovieSnapshot) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if previous == nil {
+		changes["title"] = FieldChange{To: s.Title}
+		changes["year"] = FieldChange{To: s.Year}
+		changes["runtime"] = FieldChange{To: s.Runtime}
+		changes["genres"] = FieldChange{To: s.Genres}
+		return changes
+	}
+
+	if s.Title != previous.Title {
+		changes["title"] = FieldChange{From: previous.Title, To: s.Title}
+	}
+	if s.Year != previous.Year {
+		changes["year"] = FieldChange{From: previous.Year, To: s.Year}
+	}
+	if s.Runtime != previous.Runtime {
+		changes["runtime"] = FieldChange{From: previous.Runtime, To: s.Runtime}
+	}
+	if !equalStrings(s.Genres, previous.Genres) {
+		changes["genres"] = FieldChange{From: previous.Genres, To: s.Genres}
+	}
+	if (s.DeletedAt == nil) != (previous.DeletedAt == nil) ||
+		s.DeletedAt != nil && !s.DeletedAt.Equal(*previous.DeletedAt) {
+		changes["deleted_at"] = FieldChange{From: previous.DeletedAt, To: s.DeletedAt}
+	}
+
+	return changes
+}
+
+// Create will insert a new revision in the database. The changes of the revision are
+// the differences with the previous revision of the movie.
+func (r MovieRevisionRepository) Create(ctx context.Context, revision *MovieRevision) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var previous *MovieSnapshot
+
+	var js []byte
+	err := r.DB.QueryRow(ctx, readPreviousRevisionSQL, revision.MovieID, revision.Version).Scan(&js)
+	switch {
+	case err == nil:
+		previous = new(MovieSnapshot)
+		err = json.Unmarshal(js, previous)
+		if err != nil {
+			return err
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return queryError(err)
+	}
+
+	revision.Changes = revision.Snapshot.changes(previous)
+
+	snapshot, changes, err := revision.marshal()
+	if err != nil {
+		return err
+	}
+
+	args := []any{revision.MovieID, revision.Version, revision.Operation, snapshot, changes, revision.ChangedBy}
+
+	err = r.DB.QueryRow(ctx, createRevisionSQL, args...).Scan(&revision.CreatedAt)
+	return queryError(err)
+}
+
+// CreateMany will insert the revisions of new movies in the database with a single COPY.
+// The creation times of the revisions are not read back.
+func (r MovieRevisionRepository) CreateMany(ctx context.Context, revisions []*MovieRevision) error {
+	rows := make([][]any, len(revisions))
+	for i, revision := range revisions {
+		revision.Changes = revision.Snapshot.changes(nil)
+
+		snapshot, changes, err := revision.marshal()
+		if err != nil {
+			return err
+		}
+
+		rows[i] = []any{revision.MovieID, revision.Version, revision.Operation, snapshot, changes, revision.ChangedBy}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	columns := []string{"movie_id", "version", "operation", "snapshot", "changes", "changed_by"}
+	_, err := r.DB.CopyFrom(ctx, pgx.Identifier{"movie_revisions"}, columns, pgx.CopyFromRows(rows))
+	return queryError(err)
+}
+
+// Read will fetch a revision of a movie from the database.
+func (r MovieRevisionRepository) Read(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var (
+		revision          MovieRevision
+		snapshot, changes []byte
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, readRevisionSQL, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&revision.Operation,
+		&snapshot,
+		&changes,
+		&revision.ChangedBy,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	err = revision.unmarshal(snapshot, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// ReadAllForMovie will fetch the revisions of a movie based on the provided filters.
+func (r MovieRevisionRepository) ReadAllForMovie(ctx context.Context, movieID int64, filters database.Filters) ([]*MovieRevision, database.Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), movie_id, version, created_at, operation, snapshot, changes, changed_by
+        FROM movie_revisions
+        WHERE movie_id = $1
+        ORDER BY %s %s, version ASC
+        LIMIT $2 OFFSET $3`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	args := []any{movieID, filters.Limit(), filters.Offset()}
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var (
+			revision          MovieRevision
+			snapshot, changes []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.Operation,
+			&snapshot,
+			&changes,
+			&revision.ChangedBy,
+		)
+		if err != nil {
+			return nil, database.Metadata{}, queryError(err)
+		}
+
+		err = revision.unmarshal(snapshot, changes)
+		if err != nil {
+			return nil, database.Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+
+	metadata := database.NewMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// marshal encodes the snapshot and the changes of the revision as stored in the database.
+func (rv MovieRevision) marshal() ([]byte, []byte, error) {
+	snapshot, err := json.Marshal(rv.Snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := json.Marshal(rv.Changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return snapshot, changes, nil
+}
+
+// unmarshal decodes the snapshot and the changes of the revision as stored in the database.
+func (rv *MovieRevision) unmarshal(snapshot, changes []byte) error {
+	err := json.Unmarshal(snapshot, &rv.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(changes, &rv.Changes)
+}
+
+// equalStrings reports whether the two slices hold the same strings in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base32"
+	"time"
+
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+)
+
+//go:embed queries/tokens/create.sql
+var createTokenSQL string
+
+//go:embed queries/tokens/delete_all_for_user.sql
+var deleteAllTokensForUserSQL string
+
+//go:embed queries/tokens/delete_all_scopes_for_user.sql
+var deleteAllScopesTokensForUserSQL string
+
+// Scopes in which a token can be used.
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+)
+
+type (
+	// Token holds the data for an individual token. It includes the
+	// plaintext and hashed versions of the token, associated user ID,
+	// expiry time and scope.
+	Token struct {
+		Plaintext string    `json:"token"`
+		Hash      []byte    `json:"-"`
+		UserID    int64     `json:"-"`
+		Expiry    time.Time `json:"expiry"`
+		Scope     string    `json:"-"`
+	}
+
+	// TokenRepository manages the set of APIs for token database access.
+	TokenRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+// generateToken creates a new token for the given user, valid for the ttl duration.
+func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	// Fill a 16 bytes slice with random bytes from the operating system's CSPRNG.
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode the random bytes to a base-32 string without padding, which results
+	// in a 26 characters long plaintext token.
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	// Only the SHA-256 hash of the plaintext token is stored in the database.
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+// ValidateTokenPlaintext checks that the plaintext token has been provided
+// and is exactly 26 bytes long.
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// New generates a new token for the given user and inserts it in the database.
+func (r TokenRepository) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Create(ctx, token)
+	return token, err
+}
+
+// Create will insert a new token in the database.
+func (r TokenRepository) Create(ctx context.Context, token *Token) error {
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.Exec(ctx, createTokenSQL, args...)
+	return queryError(err)
+}
+
+// DeleteAllForUser will delete all tokens for a specific user and scope.
+func (r TokenRepository) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.Exec(ctx, deleteAllTokensForUserSQL, scope, userID)
+	return queryError(err)
+}
+
+// DeleteAllScopesForUser will delete every token of a specific user, regardless of scope.
+func (r TokenRepository) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.Exec(ctx, deleteAllScopesTokensForUserSQL, userID)
+	return queryError(err)
+}
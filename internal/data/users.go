@@ -2,13 +2,13 @@ package data
 
 import (
 	"context"
-	"database/sql"
+	"crypto/sha256"
 	_ "embed"
 	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mroobert/json-api/internal/database"
 	"github.com/mroobert/json-api/internal/validator"
 	"golang.org/x/crypto/bcrypt"
@@ -23,10 +23,16 @@ var readUserSQL string
 //go:embed queries/users/update.sql
 var updateUserSQL string
 
+//go:embed queries/users/read_for_token.sql
+var readUserForTokenSQL string
+
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// AnonymousUser represents a user who has not been authenticated.
+var AnonymousUser = &User{}
+
 type (
 
 	// User represent an individual user.
@@ -49,7 +55,8 @@ type (
 
 	// UserRepository manages the set of APIs for user database access.
 	UserRepository struct {
-		DB *pgxpool.Pool
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
 	}
 
 	// password contains the plaintext and hashed versions of the password for a user.
@@ -62,6 +69,11 @@ type (
 	}
 )
 
+// IsAnonymous checks if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
 func (u User) Validate(v *validator.Validator) {
 	v.Check(u.Name != "", "name", "must be provided")
 	v.Check(len(u.Name) <= 500, "name", "must not be more than 500 bytes long")
@@ -89,10 +101,10 @@ func (u *User) FromNewUser(input NewUser) {
 }
 
 // Create will insert a new user in the database.
-func (r UserRepository) Create(user *User) error {
+func (r UserRepository) Create(ctx context.Context, user *User) error {
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	err := r.DB.QueryRow(ctx, createUserSQL, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -103,7 +115,7 @@ func (r UserRepository) Create(user *User) error {
 				return ErrDuplicateEmail
 			}
 		}
-		return err
+		return queryError(err)
 	}
 
 	return nil
@@ -112,10 +124,10 @@ func (r UserRepository) Create(user *User) error {
 // Read will fetch a user from the database.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record or none at all.
-func (r UserRepository) Read(email string) (*User, error) {
+func (r UserRepository) Read(ctx context.Context, email string) (*User, error) {
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	err := r.DB.QueryRow(ctx, readUserSQL, email).Scan(
@@ -130,10 +142,10 @@ func (r UserRepository) Read(email string) (*User, error) {
 
 	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, pgx.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(err)
 		}
 	}
 
@@ -142,7 +154,7 @@ func (r UserRepository) Read(email string) (*User, error) {
 
 // Update will update a user from the database.
 // This operation is implementing optimistic locking.
-func (r UserRepository) Update(user *User) error {
+func (r UserRepository) Update(ctx context.Context, user *User) error {
 	args := []any{
 		user.Name,
 		user.Email,
@@ -152,7 +164,7 @@ func (r UserRepository) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	err := r.DB.QueryRow(ctx, updateUserSQL, args...).Scan(&user.Version)
@@ -163,12 +175,48 @@ func (r UserRepository) Update(user *User) error {
 				return ErrDuplicateEmail
 			}
 		}
-		return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return queryError(err)
 	}
 
 	return nil
 }
 
+// ReadForToken will fetch the user associated with a token of the given scope.
+// Only tokens that have not yet expired are taken into account.
+func (r UserRepository) ReadForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, readUserForTokenSQL, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	return &user, nil
+}
+
 // Set calculates the bcrypt hash of a plaintext password, and stores both
 // the hash and the plaintext versions in the struct.
 func (p *password) Set(plainTextPassword string) error {
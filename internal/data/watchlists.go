@@ -0,0 +1,550 @@
+package data
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mroobert/json-api/internal/database"
+	"github.com/mroobert/json-api/internal/validator"
+)
+
+//go:embed queries/watchlists/create.sql
+var createWatchlistSQL string
+
+//go:embed queries/watchlists/read.sql
+var readWatchlistSQL string
+
+//go:embed queries/watchlists/update.sql
+var updateWatchlistSQL string
+
+//go:embed queries/watchlists/delete.sql
+var deleteWatchlistSQL string
+
+//go:embed queries/watchlists/lock.sql
+var lockWatchlistSQL string
+
+//go:embed queries/watchlists/count_items.sql
+var countWatchlistItemsSQL string
+
+//go:embed queries/watchlists/shift_items.sql
+var shiftWatchlistItemsSQL string
+
+//go:embed queries/watchlists/add_item.sql
+var addWatchlistItemSQL string
+
+//go:embed queries/watchlists/read_item.sql
+var readWatchlistItemSQL string
+
+//go:embed queries/watchlists/update_item.sql
+var updateWatchlistItemSQL string
+
+//go:embed queries/watchlists/remove_item.sql
+var removeWatchlistItemSQL string
+
+var (
+	ErrDuplicateWatchlist      = errors.New("duplicate watchlist")
+	ErrDuplicateWatchlistMovie = errors.New("duplicate watchlist movie")
+)
+
+type (
+	// Watchlist represents a named list of movies kept by a user.
+	Watchlist struct {
+		ID        int64     `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+		UserID    int64     `json:"user_id"`
+		Name      string    `json:"name"`
+		Version   int32     `json:"version"`
+	}
+
+	// NewWatchlist contains information needed to create a new watchlist.
+	NewWatchlist struct {
+		Name string `json:"name"`
+	}
+
+	// UpdateWatchlist contains information needed to update a Watchlist.
+	UpdateWatchlist struct {
+		Name *string `json:"name"`
+	}
+
+	// WatchlistItem represents a movie of a watchlist. The items of a watchlist
+	// are ordered by their position, which starts from 1.
+	WatchlistItem struct {
+		WatchlistID int64      `json:"watchlist_id"`
+		MovieID     int64      `json:"movie_id"`
+		Position    int32      `json:"position"`
+		AddedAt     time.Time  `json:"added_at"`
+		WatchedAt   *time.Time `json:"watched_at"` // Nil until the movie is marked as watched
+		Movie       *Movie     `json:"movie,omitempty"`
+	}
+
+	// NewWatchlistItem contains information needed to add a movie to a watchlist.
+	// A missing position appends the movie at the end of the watchlist.
+	NewWatchlistItem struct {
+		MovieID  int64 `json:"movie_id"`
+		Position int32 `json:"position"`
+	}
+
+	// UpdateWatchlistItem contains information needed to update a WatchlistItem.
+	// All fields are optional so clients can send just the fields they want changed.
+	UpdateWatchlistItem struct {
+		Position *int32 `json:"position"`
+		Watched  *bool  `json:"watched"`
+	}
+
+	// WatchlistRepository manages the set of APIs for watchlist database access.
+	// The changes of the items lock the watchlist, so their positions are
+	// kept contiguous under concurrent changes.
+	WatchlistRepository struct {
+		DB      database.DBTX
+		Timeout time.Duration // Time budget of a single query
+	}
+)
+
+func (wl Watchlist) Validate(vld *validator.Validator) {
+	vld.Check(wl.Name != "", "name", "must be provided")
+	vld.Check(len(wl.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+func (wl *Watchlist) FromNewWatchlist(input NewWatchlist) {
+	wl.Name = input.Name
+}
+
+func (wl *Watchlist) FromUpdateWatchlist(input UpdateWatchlist) {
+	if input.Name != nil {
+		wl.Name = *input.Name
+	}
+}
+
+func (wi WatchlistItem) Validate(vld *validator.Validator) {
+	vld.Check(wi.MovieID > 0, "movie_id", "must be provided")
+	vld.Check(wi.Position >= 0, "position", "must not be negative")
+}
+
+func (wi *WatchlistItem) FromNewWatchlistItem(input NewWatchlistItem) {
+	wi.MovieID = input.MovieID
+	wi.Position = input.Position
+}
+
+// FromUpdateWatchlistItem applies the changes of the input. Marking a movie as
+// watched keeps the time it was first marked.
+func (wi *WatchlistItem) FromUpdateWatchlistItem(input UpdateWatchlistItem) {
+	if input.Position != nil {
+		wi.Position = *input.Position
+	}
+	if input.Watched != nil {
+		switch {
+		case !*input.Watched:
+			wi.WatchedAt = nil
+		case wi.WatchedAt == nil:
+			now := time.Now()
+			wi.WatchedAt = &now
+		}
+	}
+}
+
+// Create will insert a new watchlist in the database.
+// The names of the watchlists of a user are unique.
+func (r WatchlistRepository) Create(ctx context.Context, watchlist *Watchlist) error {
+	args := []any{watchlist.UserID, watchlist.Name}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, createWatchlistSQL, args...).Scan(&watchlist.ID, &watchlist.CreatedAt, &watchlist.UpdatedAt, &watchlist.Version)
+	if err != nil {
+		var pgError *pgconn.PgError
+		switch {
+		case errors.As(err, &pgError) && pgError.Code == database.UniqueViolation:
+			return ErrDuplicateWatchlist
+		default:
+			return queryError(err)
+		}
+	}
+
+	return nil
+}
+
+// Read will fetch a watchlist from the database.
+func (r WatchlistRepository) Read(ctx context.Context, id int64) (*Watchlist, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var watchlist Watchlist
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, readWatchlistSQL, id).Scan(
+		&watchlist.ID,
+		&watchlist.CreatedAt,
+		&watchlist.UpdatedAt,
+		&watchlist.UserID,
+		&watchlist.Name,
+		&watchlist.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	return &watchlist, nil
+}
+
+// Update will update a watchlist from the database.
+// This operation is implementing optimistic locking.
+func (r WatchlistRepository) Update(ctx context.Context, watchlist *Watchlist) error {
+	args := []any{watchlist.Name, watchlist.ID, watchlist.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRow(ctx, updateWatchlistSQL, args...).Scan(&watchlist.UpdatedAt, &watchlist.Version)
+	if err != nil {
+		var pgError *pgconn.PgError
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		case errors.As(err, &pgError) && pgError.Code == database.UniqueViolation:
+			return ErrDuplicateWatchlist
+		default:
+			return queryError(err)
+		}
+	}
+
+	return nil
+}
+
+// Delete will delete a watchlist, together with its items, from the database.
+func (r WatchlistRepository) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.Exec(ctx, deleteWatchlistSQL, id)
+	if err != nil {
+		return queryError(err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ReadAllForUser will fetch the watchlists of a user based on the provided filters.
+func (r WatchlistRepository) ReadAllForUser(ctx context.Context, userID int64, filters database.Filters) ([]*Watchlist, database.Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, updated_at, user_id, name, version
+        FROM watchlists
+        WHERE user_id = $1
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	args := []any{userID, filters.Limit(), filters.Offset()}
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	watchlists := []*Watchlist{}
+	for rows.Next() {
+		var watchlist Watchlist
+
+		err := rows.Scan(
+			&totalRecords,
+			&watchlist.ID,
+			&watchlist.CreatedAt,
+			&watchlist.UpdatedAt,
+			&watchlist.UserID,
+			&watchlist.Name,
+			&watchlist.Version,
+		)
+		if err != nil {
+			return nil, database.Metadata{}, queryError(err)
+		}
+
+		watchlists = append(watchlists, &watchlist)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+
+	metadata := database.NewMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return watchlists, metadata, nil
+}
+
+// AddItem will insert a movie in a watchlist, at the position of the item.
+// The items from that position onwards are moved one position down. A missing
+// or out of range position appends the movie at the end of the watchlist.
+func (r WatchlistRepository) AddItem(ctx context.Context, item *WatchlistItem) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		count, err := lockWatchlist(ctx, tx, item.WatchlistID)
+		if err != nil {
+			return err
+		}
+
+		if item.Position < 1 || item.Position > count+1 {
+			item.Position = count + 1
+		}
+
+		_, err = tx.Exec(ctx, shiftWatchlistItemsSQL, item.WatchlistID, item.Position, count, 1)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx, addWatchlistItemSQL, item.WatchlistID, item.MovieID, item.Position).Scan(&item.AddedAt)
+		if err != nil {
+			var pgError *pgconn.PgError
+			if errors.As(err, &pgError) {
+				switch pgError.Code {
+				case database.UniqueViolation:
+					return ErrDuplicateWatchlistMovie
+				case database.ForeignKeyViolation:
+					return ErrRecordNotFound
+				}
+			}
+			return err
+		}
+
+		return nil
+	})
+
+	return queryError(err)
+}
+
+// ReadItem will fetch a movie of a watchlist from the database.
+func (r WatchlistRepository) ReadItem(ctx context.Context, watchlistID, movieID int64) (*WatchlistItem, error) {
+	if watchlistID < 1 || movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	item, err := readWatchlistItem(ctx, r.DB, watchlistID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(err)
+		}
+	}
+
+	return item, nil
+}
+
+// UpdateItem will update the position and the watched time of a movie of a watchlist.
+// The items between the old and the new position are moved by one position to make
+// room for the item. An out of range position moves the item at the end of the watchlist.
+func (r WatchlistRepository) UpdateItem(ctx context.Context, item *WatchlistItem) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		count, err := lockWatchlist(ctx, tx, item.WatchlistID)
+		if err != nil {
+			return err
+		}
+
+		current, err := readWatchlistItem(ctx, tx, item.WatchlistID, item.MovieID)
+		if err != nil {
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if item.Position < 1 || item.Position > count {
+			item.Position = count
+		}
+
+		switch {
+		case item.Position < current.Position:
+			_, err = tx.Exec(ctx, shiftWatchlistItemsSQL, item.WatchlistID, item.Position, current.Position-1, 1)
+		case item.Position > current.Position:
+			_, err = tx.Exec(ctx, shiftWatchlistItemsSQL, item.WatchlistID, current.Position+1, item.Position, -1)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, updateWatchlistItemSQL, item.WatchlistID, item.MovieID, item.Position, item.WatchedAt)
+		return err
+	})
+
+	return queryError(err)
+}
+
+// RemoveItem will delete a movie from a watchlist.
+// The items after it are moved one position up.
+func (r WatchlistRepository) RemoveItem(ctx context.Context, watchlistID, movieID int64) error {
+	if watchlistID < 1 || movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.DB, func(tx pgx.Tx) error {
+		count, err := lockWatchlist(ctx, tx, watchlistID)
+		if err != nil {
+			return err
+		}
+
+		var position int32
+		err = tx.QueryRow(ctx, removeWatchlistItemSQL, watchlistID, movieID).Scan(&position)
+		if err != nil {
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, shiftWatchlistItemsSQL, watchlistID, position+1, count, -1)
+		return err
+	})
+
+	return queryError(err)
+}
+
+// ReadAllItems will fetch the movies of a watchlist based on the provided filters.
+// The items carry the movie they refer to; the soft deleted movies are left out.
+func (r WatchlistRepository) ReadAllItems(ctx context.Context, watchlistID int64, filters database.Filters) ([]*WatchlistItem, database.Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), watchlist_items.watchlist_id, watchlist_items.movie_id, watchlist_items.position,
+            watchlist_items.added_at, watchlist_items.watched_at,
+            movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+            movies.average_rating, movies.review_count
+        FROM watchlist_items
+        INNER JOIN movies ON movies.id = watchlist_items.movie_id
+        WHERE watchlist_items.watchlist_id = $1 AND movies.deleted_at IS NULL
+        ORDER BY %s %s NULLS LAST, watchlist_items.position ASC
+        LIMIT $2 OFFSET $3`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	args := []any{watchlistID, filters.Limit(), filters.Offset()}
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WatchlistItem{}
+	for rows.Next() {
+		var (
+			item  WatchlistItem
+			movie Movie
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&item.WatchlistID,
+			&item.MovieID,
+			&item.Position,
+			&item.AddedAt,
+			&item.WatchedAt,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			&movie.Genres,
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+		)
+		if err != nil {
+			return nil, database.Metadata{}, queryError(err)
+		}
+
+		movie.ID = item.MovieID
+		item.Movie = &movie
+		items = append(items, &item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, database.Metadata{}, queryError(err)
+	}
+
+	metadata := database.NewMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
+
+// lockWatchlist locks the watchlist until the end of the transaction
+// and returns the number of its items. The items are counted in a statement of
+// their own, whose snapshot is taken once the lock is held, so that the items
+// of the concurrent transactions which held it before are counted.
+func lockWatchlist(ctx context.Context, tx pgx.Tx, watchlistID int64) (int32, error) {
+	var locked int
+
+	err := tx.QueryRow(ctx, lockWatchlistSQL, watchlistID).Scan(&locked)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	var count int32
+
+	err = tx.QueryRow(ctx, countWatchlistItemsSQL, watchlistID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// readWatchlistItem reads a movie of a watchlist. It returns pgx.ErrNoRows
+// when the movie is not in the watchlist.
+func readWatchlistItem(ctx context.Context, db database.DBTX, watchlistID, movieID int64) (*WatchlistItem, error) {
+	var item WatchlistItem
+
+	err := db.QueryRow(ctx, readWatchlistItemSQL, watchlistID, movieID).Scan(
+		&item.WatchlistID,
+		&item.MovieID,
+		&item.Position,
+		&item.AddedAt,
+		&item.WatchedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
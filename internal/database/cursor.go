@@ -0,0 +1,64 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points at a row from which the keyset pagination continues.
+// It holds the value of the sort column and the id of the row, which is the tiebreaker.
+type Cursor struct {
+	Sort     string `json:"s"`           // Sort parameter the cursor was created for
+	Value    string `json:"v"`           // Value of the sort column
+	ID       int64  `json:"i"`           // ID of the row
+	Backward bool   `json:"b,omitempty"` // Whether the rows before the cursor are requested
+}
+
+// Encode returns the opaque representation of the cursor, signed with the secret
+// so that clients can't forge or alter it.
+func (c Cursor) Encode(secret []byte) string {
+	payload, _ := json.Marshal(c)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor verifies the signature of an encoded cursor and decodes it.
+func DecodeCursor(encoded string, secret []byte) (*Cursor, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(payload, &cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
@@ -3,12 +3,30 @@ package database
 
 import (
 	"context"
+	"log/slog"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/tracelog"
 )
 
-const UniqueViolation = "23505"
+// PostgreSQL error codes.
+const (
+	ForeignKeyViolation = "23503"
+	UniqueViolation     = "23505"
+)
+
+// DBTX is the set of methods shared by *pgxpool.Pool and pgx.Tx. It allows the
+// repositories to run their queries either directly on the pool or inside a transaction.
+type DBTX interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
 
 // Config represents configuration properties for using the database.
 //
@@ -33,24 +51,39 @@ const UniqueViolation = "23505"
 // swapping databases.
 type Config struct {
 	DSN             string
-	MaxOpenConns    int    // limit on the number of ‘open’ connections (in-use + idle connections)
-	MinConns        int    // minimum size of the pool
-	MaxConnIdleTime string // sets the maximum length of time that a connection can be idle for before it is marked as expired
+	MaxOpenConns    int           // limit on the number of ‘open’ connections (in-use + idle connections)
+	MinConns        int           // minimum size of the pool
+	MaxConnIdleTime string        // sets the maximum length of time that a connection can be idle for before it is marked as expired
+	QueryTimeout    time.Duration // time budget of a single query, on top of the deadline of the caller's context
+	MigrateOnStart  bool          // apply the pending migrations when the application starts
+	QueryLogger     *slog.Logger  // logs the queries (without their arguments) at the DEBUG level and their errors at the WARN level, if set
 }
 
 // OpenConnection knows how to open a database connection based on the configuration.
 func OpenConnection(cfg Config) (*pgxpool.Pool, error) {
-	pool, err := pgxpool.New(context.Background(), cfg.DSN)
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN)
 	if err != nil {
 		return nil, err
 	}
-	pool.Config().MaxConns = int32(cfg.MaxOpenConns)
+	poolConfig.MaxConns = int32(cfg.MaxOpenConns)
 	duration, err := time.ParseDuration(cfg.MaxConnIdleTime)
 	if err != nil {
 		return nil, err
 	}
-	pool.Config().MaxConnIdleTime = duration
-	pool.Config().MinConns = int32(cfg.MinConns)
+	poolConfig.MaxConnIdleTime = duration
+	poolConfig.MinConns = int32(cfg.MinConns)
+
+	if cfg.QueryLogger != nil {
+		poolConfig.ConnConfig.Tracer = &tracelog.TraceLog{
+			Logger:   queryLogger{cfg.QueryLogger},
+			LogLevel: tracelog.LogLevelInfo,
+		}
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -62,3 +95,32 @@ func OpenConnection(cfg Config) (*pgxpool.Pool, error) {
 
 	return pool, nil
 }
+
+// queryLogger logs the traces of pgx with a slog.Logger. The successful queries are
+// logged at the DEBUG level and the failed ones at the WARN level, since most of their
+// errors (e.g. unique violations) are handled by the application.
+type queryLogger struct {
+	logger *slog.Logger
+}
+
+func (l queryLogger) Log(ctx context.Context, level tracelog.LogLevel, msg string, data map[string]any) {
+	slogLevel := slog.LevelDebug
+	if level <= tracelog.LogLevelWarn {
+		slogLevel = slog.LevelWarn
+	}
+
+	if !l.logger.Enabled(ctx, slogLevel) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, len(data))
+	for key, value := range data {
+		// The arguments are left out, since they hold secrets such as the password hashes.
+		if key == "args" {
+			continue
+		}
+		attrs = append(attrs, slog.Any(key, value))
+	}
+
+	l.logger.LogAttrs(ctx, slogLevel, msg, slog.Attr{Key: "db", Value: slog.GroupValue(attrs...)})
+}
@@ -15,15 +15,24 @@ type (
 		PageSize     int
 		Sort         string
 		SortSafelist []string
+		Keyset       bool    // Use the keyset (cursor) pagination instead of the page numbers
+		Cursor       *Cursor // Position of the keyset pagination; nil for the first page
 	}
 
 	// Metadata holds pagination metadata.
 	Metadata struct {
-		CurrentPage  int `json:"current_page,omitempty"`
-		PageSize     int `json:"page_size,omitempty"`
-		FirstPage    int `json:"first_page,omitempty"`
-		LastPage     int `json:"last_page,omitempty"`
-		TotalRecords int `json:"total_records,omitempty"`
+		CurrentPage  int    `json:"current_page,omitempty"`
+		PageSize     int    `json:"page_size,omitempty"`
+		FirstPage    int    `json:"first_page,omitempty"`
+		LastPage     int    `json:"last_page,omitempty"`
+		TotalRecords int    `json:"total_records,omitempty"`
+		NextCursor   string `json:"next_cursor,omitempty"`
+		PrevCursor   string `json:"prev_cursor,omitempty"`
+
+		// Next and Prev are the cursors of the keyset pagination, before being encoded
+		// into NextCursor and PrevCursor.
+		Next *Cursor `json:"-"`
+		Prev *Cursor `json:"-"`
 	}
 )
 
@@ -41,10 +50,52 @@ func NewMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
+// NewKeysetMetadata creates the metadata of a page of n rows fetched with the keyset
+// pagination. The rows must be in their final order, hasMore reports whether there are
+// more rows beyond the page in the walking direction, and cursorAt creates the cursor
+// pointing at the i-th row.
+func NewKeysetMetadata(f Filters, n int, hasMore bool, cursorAt func(i int) Cursor) Metadata {
+	metadata := Metadata{PageSize: f.PageSize}
+	if n == 0 {
+		return metadata
+	}
+
+	backward := f.Cursor != nil && f.Cursor.Backward
+
+	// Coming from a cursor means there are rows on the side we came from.
+	if hasMore && !backward || f.Cursor != nil && backward {
+		next := cursorAt(n - 1)
+		next.Sort, next.Backward = f.Sort, false
+		metadata.Next = &next
+	}
+	if hasMore && backward || f.Cursor != nil && !backward {
+		prev := cursorAt(0)
+		prev.Sort, prev.Backward = f.Sort, true
+		metadata.Prev = &prev
+	}
+
+	return metadata
+}
+
+// EncodeCursors fills NextCursor and PrevCursor with the signed representation
+// of the keyset pagination cursors.
+func (m *Metadata) EncodeCursors(secret []byte) {
+	if m.Next != nil {
+		m.NextCursor = m.Next.Encode(secret)
+	}
+	if m.Prev != nil {
+		m.PrevCursor = m.Prev.Encode(secret)
+	}
+}
+
 // ValidateFilters checks if the filters are valid.
 func (f Filters) ValidateFilters(v *validator.Validator) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	if f.Keyset {
+		v.Check(f.Cursor == nil || f.Cursor.Sort == f.Sort, "cursor", "does not match the sort parameter")
+	} else {
+		v.Check(f.Page > 0, "page", "must be greater than zero")
+		v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	}
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
@@ -70,6 +121,28 @@ func (f Filters) SortDirection() string {
 	return "ASC"
 }
 
+// KeysetOperators computes the comparison operators which select the rows after the cursor
+// (before it, for a backward cursor) and the ordering directions of the sort column and of
+// the id tiebreaker. The id is always sorted ascending within equal values of the sort column.
+func (f Filters) KeysetOperators() (columnOp, idOp, columnDirection, idDirection string) {
+	columnOp, idOp = ">", ">"
+	columnDirection, idDirection = "ASC", "ASC"
+	if f.SortDirection() == "DESC" {
+		columnOp, columnDirection = "<", "DESC"
+	}
+
+	// Walking backwards flips the ordering; the rows are reversed afterwards.
+	if f.Cursor != nil && f.Cursor.Backward {
+		flipOp := map[string]string{">": "<", "<": ">"}
+		flipDirection := map[string]string{"ASC": "DESC", "DESC": "ASC"}
+
+		columnOp, idOp = flipOp[columnOp], flipOp[idOp]
+		columnDirection, idDirection = flipDirection[columnDirection], flipDirection[idDirection]
+	}
+
+	return columnOp, idOp, columnDirection, idDirection
+}
+
 // Limit represents maximum number of records that a SQL query should return.
 func (f Filters) Limit() int {
 	return f.PageSize
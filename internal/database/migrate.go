@@ -0,0 +1,421 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationsLockID is the key of the advisory lock held while migrating, so that
+// several instances starting at the same time don't run the migrations concurrently.
+const migrationsLockID = 4_818_284_719_223
+
+var migrationFileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	ErrDirtyLegacy      = errors.New("legacy schema_migrations table is dirty")
+)
+
+type (
+	// Migration is a single schema change, made of the SQL statements that
+	// apply it (Up) and revert it (Down).
+	Migration struct {
+		Version  int64
+		Name     string
+		Up       string
+		Down     string
+		Checksum string // SHA-256 of the Up statements
+	}
+
+	// MigrationStatus describes the state of a migration in the database.
+	MigrationStatus struct {
+		Version   int64
+		Name      string
+		Applied   bool
+		AppliedAt time.Time
+		Modified  bool // the file changed since the migration was applied
+		Missing   bool // the migration was applied but its file no longer exists
+	}
+
+	// Migrator applies and reverts the migrations, keeping track of them
+	// in the schema_migrations table. The table left by golang-migrate, which only
+	// has the version of the last applied migration, is converted on first use.
+	Migrator struct {
+		db         *pgxpool.Pool
+		migrations []Migration // sorted by version
+	}
+
+	// appliedMigration is a row of the schema_migrations table.
+	appliedMigration struct {
+		name      string
+		checksum  string
+		appliedAt time.Time
+	}
+)
+
+// NewMigrator reads the migration files from fsys and creates a Migrator for them.
+func NewMigrator(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := migrationFileRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has more than one name", version)
+		}
+
+		switch matches[3] {
+		case "up":
+			sum := sha256.Sum256(content)
+			m.Up = string(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		case "down":
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up statements", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies all the pending migrations and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	up, _, err := m.migrate(ctx, func(applied map[int64]appliedMigration) ([]Migration, []Migration) {
+		return m.pending(applied, -1), nil
+	})
+	return up, err
+}
+
+// Down reverts the last n applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	_, down, err := m.migrate(ctx, func(applied map[int64]appliedMigration) ([]Migration, []Migration) {
+		down := m.applied(applied, 0)
+		if len(down) > n {
+			down = down[:n]
+		}
+		return nil, down
+	})
+	return down, err
+}
+
+// Goto migrates the schema up or down to the given version. Version 0 reverts
+// every migration. It returns the applied and the reverted migrations.
+func (m *Migrator) Goto(ctx context.Context, version int64) ([]Migration, []Migration, error) {
+	if version != 0 && m.find(version) == nil {
+		return nil, nil, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.migrate(ctx, func(applied map[int64]appliedMigration) ([]Migration, []Migration) {
+		return m.pending(applied, version), m.applied(applied, version)
+	})
+}
+
+// Status reports the state of every known migration.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	err := m.prepareTable(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.readApplied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.appliedAt
+			status.Modified = a.checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, a := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Version:   version,
+			Name:      a.name,
+			Applied:   true,
+			AppliedAt: a.appliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// migrate holds the advisory lock while it applies and reverts the migrations
+// selected by plan, and returns them. Every migration runs in its own transaction.
+func (m *Migrator) migrate(ctx context.Context, plan func(map[int64]appliedMigration) (up, down []Migration)) ([]Migration, []Migration, error) {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+
+	err = m.prepareTable(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The state is read after acquiring the lock, so the migrations applied
+	// meanwhile by another instance are taken into account.
+	applied, err := m.readApplied(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for version, a := range applied {
+		if migration := m.find(version); migration != nil && migration.Checksum != a.checksum {
+			return nil, nil, fmt.Errorf("%w: version %d", ErrChecksumMismatch, version)
+		}
+	}
+
+	up, down := plan(applied)
+
+	for _, migration := range down {
+		if migration.Down == "" {
+			return nil, nil, fmt.Errorf("migration %d has no down statements", migration.Version)
+		}
+
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, migration.Down)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("reverting migration %d: %w", migration.Version, err)
+		}
+	}
+
+	for _, migration := range up {
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, migration.Up)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, migration.Checksum)
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("applying migration %d: %w", migration.Version, err)
+		}
+	}
+
+	return up, down, nil
+}
+
+// pending returns the migrations not yet applied, up to the given version
+// (-1 for all), in ascending order.
+func (m *Migrator) pending(applied map[int64]appliedMigration, upTo int64) []Migration {
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if upTo >= 0 && migration.Version > upTo {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// applied returns the applied migrations with a version greater than
+// the given one, in descending order.
+func (m *Migrator) applied(applied map[int64]appliedMigration, above int64) []Migration {
+	var migrations []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= above {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			migrations = append(migrations, migration)
+		}
+	}
+	return migrations
+}
+
+// find returns the migration with the given version, or nil.
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// prepareTable creates the schema_migrations table if it doesn't exist,
+// or converts the one left by golang-migrate.
+func (m *Migrator) prepareTable(ctx context.Context, db DBTX) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		// The lock is reentrant, so it's also taken while migrate holds it.
+		_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationsLockID)
+		if err != nil {
+			return err
+		}
+
+		var legacy bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'schema_migrations' AND column_name = 'dirty'
+			)`).Scan(&legacy)
+		if err != nil {
+			return err
+		}
+
+		if legacy {
+			return m.adoptLegacyTable(ctx, tx)
+		}
+
+		return createTable(ctx, tx)
+	})
+}
+
+// adoptLegacyTable replaces the schema_migrations(version, dirty) table of golang-migrate,
+// which only records the last applied version, with a table recording every migration
+// up to that version. The migrations are recorded with the checksums of their current
+// files, since the ones of the files they were applied from are unknown. The legacy table
+// is kept as schema_migrations_legacy.
+func (m *Migrator) adoptLegacyTable(ctx context.Context, tx pgx.Tx) error {
+	var (
+		version int64
+		dirty   bool
+	)
+	err := tx.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		version = 0
+	case err != nil:
+		return err
+	case dirty:
+		return fmt.Errorf("%w: version %d must be fixed by hand", ErrDirtyLegacy, version)
+	case m.find(version) == nil:
+		return fmt.Errorf("legacy schema_migrations table has unknown version %d", version)
+	}
+
+	_, err = tx.Exec(ctx, "ALTER TABLE schema_migrations RENAME TO schema_migrations_legacy")
+	if err != nil {
+		return err
+	}
+
+	err = createTable(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+			migration.Version, migration.Name, migration.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createTable creates the schema_migrations table if it doesn't exist.
+func createTable(ctx context.Context, db DBTX) error {
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			checksum text NOT NULL,
+			applied_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+		)`)
+	return err
+}
+
+// readApplied fetches the applied migrations, by version.
+func (m *Migrator) readApplied(ctx context.Context, db DBTX) (map[int64]appliedMigration, error) {
+	rows, err := db.Query(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var (
+			version int64
+			a       appliedMigration
+		)
+
+		err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = a
+	}
+
+	return applied, rows.Err()
+}
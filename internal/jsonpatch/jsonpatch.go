@@ -0,0 +1,410 @@
+// Package jsonpatch applies JSON Patch (RFC 6902) and JSON Merge Patch (RFC 7396)
+// documents to JSON documents.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrTestFailed is returned when the value of a "test" operation doesn't match the document.
+var ErrTestFailed = errors.New("test operation failed")
+
+// operation is a single operation of a JSON Patch.
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"` // Empty when missing, "null" when null
+}
+
+// Apply applies the JSON Patch to the document and returns the patched document.
+// The operations are applied in order and the patch is rejected as a whole if any of
+// them fails. A failed "test" operation is reported with ErrTestFailed.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []operation
+	err := json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("patch must be an array of operations: %w", err)
+	}
+
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// apply applies the operation to the document and returns the patched document.
+func (op operation) apply(doc any) (any, error) {
+	if op.Path == nil {
+		return nil, errors.New(`missing "path" member`)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, errors.New(`missing "value" member`)
+		}
+		value, err = decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, errors.New(`missing "from" member`)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err = get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if *op.Path != *op.From && strings.HasPrefix(*op.Path, *op.From+"/") {
+				return nil, errors.New("a value can't be moved into one of its children")
+			}
+			doc, err = remove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value = clone(value)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		return replace(doc, path, value)
+	default:
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, fmt.Errorf("%w: value at %q doesn't match", ErrTestFailed, *op.Path)
+		}
+		return doc, nil
+	}
+}
+
+// Merge applies the JSON Merge Patch to the document and returns the patched document.
+func Merge(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("patch contains badly-formed JSON: %w", err)
+	}
+
+	return json.Marshal(merge(target, changes))
+}
+
+// merge implements the MergePatch function of RFC 7396.
+func merge(target, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = make(map[string]any)
+	}
+
+	for name, value := range changes {
+		if value == nil {
+			delete(object, name)
+		} else {
+			object[name] = merge(object[name], value)
+		}
+	}
+
+	return object
+}
+
+// parsePointer splits the JSON Pointer (RFC 6901) into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with a slash", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// get returns the value of the document at the path.
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []any:
+			i, err := index(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%q can't be looked up in a scalar value", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// update returns the document with the parent of the path replaced by the result of fn,
+// which receives the parent and the last token of the path.
+func update(doc any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("member %q does not exist", path[0])
+		}
+		child, err := update(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+	case []any:
+		i, err := index(path[0], len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		child, err := update(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%q can't be looked up in a scalar value", path[0])
+	}
+}
+
+// add adds the value to the document at the path. In an array, the value is inserted
+// at the index, or appended when the index is "-".
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			if token == "-" {
+				return append(node, value), nil
+			}
+			i, err := index(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("%q can't be added to a scalar value", token)
+		}
+	})
+}
+
+// remove removes the value of the document at the path.
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("the whole document can't be removed")
+	}
+
+	return update(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := index(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%q can't be removed from a scalar value", token)
+		}
+	})
+}
+
+// replace replaces the existing value of the document at the path.
+func replace(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			node[token] = value
+			return node, nil
+		case []any:
+			i, err := index(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("%q can't be replaced in a scalar value", token)
+		}
+	})
+}
+
+// index converts the token to an array index, between 0 and max.
+func index(token string, max int) (int, error) {
+	// Leading zeros are not allowed by RFC 6901.
+	if token == "" || len(token) > 1 && token[0] == '0' {
+		return 0, fmt.Errorf("%q is not a valid array index", token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%q is not a valid array index", token)
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d is out of bounds", i)
+	}
+
+	return i, nil
+}
+
+// decode decodes a single JSON value, keeping the numbers as json.Number.
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value any
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("must only contain a single JSON value")
+	}
+
+	return value, nil
+}
+
+// clone returns a deep copy of a decoded JSON value.
+func clone(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for name, member := range v {
+			object[name] = clone(member)
+		}
+		return object
+	case []any:
+		array := make([]any, len(v))
+		for i, element := range v {
+			array[i] = clone(element)
+		}
+		return array
+	default:
+		return value
+	}
+}
+
+// equal reports whether two decoded JSON values are equal, as defined for the "test"
+// operation: the numbers are compared by value and the members of objects in any order.
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, member := range x {
+			other, ok := y[name]
+			if !ok || !equal(member, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errx := x.Float64()
+		fy, erry := y.Float64()
+		return errx == nil && erry == nil && fx == fy
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,224 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// assertJSON checks that got and want hold the same JSON value.
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid result %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid expected value %s: %v", want, err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestApply(t *testing.T) {
+	// The examples of RFC 6902, Appendix A, followed by the ones of the movies.
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error // Checked with errors.Is, when not nil
+		fails   bool
+	}{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			want:  `{"foo": "bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:    "A.9 testing a value: error",
+			doc:     `{"baz": "qux"}`,
+			patch:   `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want:  `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			fails: true,
+		},
+		{
+			name:  "A.13 invalid JSON patch document",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux", "op": "remove"}]`,
+			fails: true,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want:  `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:    "A.15 comparing strings and numbers",
+			doc:     `{"/": 9, "~1": 10}`,
+			patch:   `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo": ["bar"]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:  `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		{
+			name:  "appending a genre",
+			doc:   `{"title": "Moana", "genres": ["animation"]}`,
+			patch: `[{"op": "add", "path": "/genres/-", "value": "adventure"}]`,
+			want:  `{"title": "Moana", "genres": ["animation", "adventure"]}`,
+		},
+		{
+			name:  "appending a genre to no genres",
+			doc:   `{"title": "Moana", "genres": []}`,
+			patch: `[{"op": "add", "path": "/genres/-", "value": "adventure"}]`,
+			want:  `{"title": "Moana", "genres": ["adventure"]}`,
+		},
+		{
+			name:    "failed test rejects the whole patch",
+			doc:     `{"title": "Moana", "version": 1}`,
+			patch:   `[{"op": "replace", "path": "/title", "value": "Moana 2"}, {"op": "test", "path": "/version", "value": 2}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:  "removing an out of bounds element",
+			doc:   `{"genres": ["animation"]}`,
+			patch: `[{"op": "remove", "path": "/genres/1"}]`,
+			fails: true,
+		},
+		{
+			name:  "unknown operation",
+			doc:   `{"title": "Moana"}`,
+			patch: `[{"op": "rename", "path": "/title", "value": "Moana 2"}]`,
+			fails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v; want %v", err, tt.wantErr)
+				}
+			case tt.fails:
+				if err == nil {
+					t.Fatalf("got %s; want an error", got)
+				}
+				if errors.Is(err, ErrTestFailed) {
+					t.Fatalf("got error %v; want an error other than %v", err, ErrTestFailed)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				assertJSON(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	// The examples of RFC 7396, Appendix A.
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.patch, func(t *testing.T) {
+			got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
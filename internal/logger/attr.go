@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Attr is a typed attribute of a log entry. It's the attribute of log/slog,
+// so the entries logged through the slog Handler keep their types.
+type Attr = slog.Attr
+
+// String returns an attribute with a string value.
+func String(key, value string) Attr {
+	return slog.String(key, value)
+}
+
+// Int returns an attribute with an integer value.
+func Int(key string, value int) Attr {
+	return slog.Int(key, value)
+}
+
+// Int64 returns an attribute with an integer value.
+func Int64(key string, value int64) Attr {
+	return slog.Int64(key, value)
+}
+
+// Float64 returns an attribute with a floating-point value.
+func Float64(key string, value float64) Attr {
+	return slog.Float64(key, value)
+}
+
+// Bool returns an attribute with a boolean value.
+func Bool(key string, value bool) Attr {
+	return slog.Bool(key, value)
+}
+
+// Duration returns an attribute with a duration value.
+func Duration(key string, value time.Duration) Attr {
+	return slog.Duration(key, value)
+}
+
+// Time returns an attribute with a time value.
+func Time(key string, value time.Time) Attr {
+	return slog.Time(key, value)
+}
+
+// Err returns an "error" attribute with the message of the error.
+func Err(err error) Attr {
+	return slog.Any("error", err)
+}
+
+// Group returns an attribute which nests the attributes under the key.
+func Group(key string, attrs ...Attr) Attr {
+	return slog.Attr{Key: key, Value: slog.GroupValue(attrs...)}
+}
+
+// Any returns an attribute with a value of any type.
+func Any(key string, value any) Attr {
+	return slog.Any(key, value)
+}
@@ -0,0 +1,239 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type (
+	// Entry is a log entry, as given to the Formatter.
+	Entry struct {
+		Level   Level
+		Time    time.Time
+		Message string
+		Attrs   []Attr
+		Trace   string // Stack trace, if the level has one
+	}
+
+	// Formatter encodes the log entries into lines, without the trailing newline.
+	Formatter interface {
+		Format(entry *Entry) ([]byte, error)
+	}
+
+	// JSONFormatter formats the entries as JSON objects, with the attributes
+	// under "properties". The groups become nested objects.
+	JSONFormatter struct{}
+
+	// TextFormatter formats the entries as human-friendly lines, for development:
+	//
+	//	2022-11-05T10:21:09Z INFO  starting server addr=:4000 env=development
+	//
+	// The attributes of the groups are prefixed with the keys of the groups
+	// (e.g. "db.sql=..."), and the stack trace is indented below the line.
+	TextFormatter struct{}
+)
+
+// Format formats the entry as a JSON object.
+func (JSONFormatter) Format(entry *Entry) ([]byte, error) {
+	// Holds data for the log entry.
+	aux := struct {
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
+	}{
+		Level:      entry.Level.String(),
+		Time:       entry.Time.Format(time.RFC3339),
+		Message:    entry.Message,
+		Properties: jsonObject(entry.Attrs),
+		Trace:      entry.Trace,
+	}
+
+	return json.Marshal(aux)
+}
+
+// jsonObject converts the attributes to the members of a JSON object.
+// The later attributes take precedence over the earlier ones with the same key.
+func jsonObject(attrs []Attr) map[string]any {
+	var object map[string]any
+
+	for _, attr := range attrs {
+		value := attr.Value.Resolve()
+
+		if value.Kind() == slog.KindGroup {
+			members := jsonObject(value.Group())
+			if len(members) == 0 {
+				continue
+			}
+
+			// The attributes of a group without a key are inlined.
+			if attr.Key == "" {
+				if object == nil {
+					object = make(map[string]any)
+				}
+				for key, member := range members {
+					object[key] = member
+				}
+				continue
+			}
+
+			if object == nil {
+				object = make(map[string]any)
+			}
+			object[attr.Key] = members
+			continue
+		}
+
+		if attr.Key == "" {
+			continue
+		}
+
+		if object == nil {
+			object = make(map[string]any)
+		}
+		object[attr.Key] = jsonValue(value)
+	}
+
+	return object
+}
+
+// jsonValue converts a resolved value, which isn't a group, to a JSON value.
+func jsonValue(value slog.Value) any {
+	switch value.Kind() {
+	case slog.KindString:
+		return value.String()
+	case slog.KindInt64:
+		return value.Int64()
+	case slog.KindUint64:
+		return value.Uint64()
+	case slog.KindFloat64:
+		// NaN and infinities have no JSON representation.
+		if f := value.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return value.String()
+		}
+		return value.Float64()
+	case slog.KindBool:
+		return value.Bool()
+	case slog.KindDuration:
+		return value.Duration().String()
+	case slog.KindTime:
+		return value.Time().Format(time.RFC3339Nano)
+	default:
+		switch v := value.Any().(type) {
+		case error:
+			return v.Error()
+		case json.Marshaler:
+			return v
+		case fmt.Stringer:
+			return v.String()
+		default:
+			if _, err := json.Marshal(v); err != nil {
+				return fmt.Sprint(v)
+			}
+			return v
+		}
+	}
+}
+
+// Format formats the entry as a line of text.
+func (TextFormatter) Format(entry *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(entry.Time.Format(time.RFC3339))
+	fmt.Fprintf(&buf, " %-5s ", entry.Level)
+	buf.WriteString(entry.Message)
+
+	for _, field := range textFields(nil, "", entry.Attrs) {
+		buf.WriteByte(' ')
+		buf.WriteString(field.key)
+		buf.WriteByte('=')
+		buf.WriteString(quoteText(field.value))
+	}
+
+	if entry.Trace != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(entry.Trace, "\n"), "\n") {
+			buf.WriteString("\n\t")
+			buf.WriteString(line)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// textField is a key=value field of a line of text.
+type textField struct {
+	key   string
+	value string
+}
+
+// textFields appends the fields of the attributes, with their keys prefixed.
+// The later attributes take the place of the earlier ones with the same key.
+func textFields(fields []textField, prefix string, attrs []Attr) []textField {
+	for _, attr := range attrs {
+		value := attr.Value.Resolve()
+
+		key := attr.Key
+		if prefix != "" && key != "" {
+			key = prefix + "." + key
+		} else if key == "" {
+			key = prefix
+		}
+
+		if value.Kind() == slog.KindGroup {
+			fields = textFields(fields, key, value.Group())
+			continue
+		}
+
+		if key == "" {
+			continue
+		}
+
+		var text string
+		switch v := value.Any().(type) {
+		case error:
+			text = v.Error()
+		case time.Time:
+			text = v.Format(time.RFC3339Nano)
+		default:
+			text = value.String()
+		}
+
+		replaced := false
+		for i := range fields {
+			if fields[i].key == key {
+				fields[i].value = text
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			fields = append(fields, textField{key: key, value: text})
+		}
+	}
+
+	return fields
+}
+
+// quoteText quotes a value of a line of text, if it's empty or contains
+// spaces, quotes, equal signs or non-printable characters.
+func quoteText(s string) string {
+	if s == "" {
+		return `""`
+	}
+
+	for _, c := range s {
+		if c == '"' || c == '=' || unicode.IsSpace(c) || !unicode.IsPrint(c) {
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
@@ -1,11 +1,15 @@
 package logger
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,105 +17,278 @@ import (
 type Level int8
 
 const (
-	LevelInfo  Level = iota // 0
-	LevelError              // 1
-	LevelFatal              // 2
-	LevelOff                // 3
+	LevelDebug Level = iota // 0
+	LevelInfo               // 1
+	LevelWarn               // 2
+	LevelError              // 3
+	LevelFatal              // 4
+	LevelOff                // 5
 )
 
 // String returns a human-friendly string for the severity level.
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
-// Logger holds the output destination that the log entries
-// will be written to, the minimum severity level that log entries will be written for,
-// plus a mutex for coordinating the writes.
-type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+// ParseLevel returns the severity level of its name, in any case (e.g. "warn").
+func ParseLevel(name string) (Level, error) {
+	for level := LevelDebug; level <= LevelOff; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", name)
 }
 
+type (
+	// Logger writes the log entries at or above a minimum severity level, along with its
+	// attributes. The child loggers created by With share the output, the level,
+	// the formatter and the stack trace policy of their parent.
+	Logger struct {
+		sink  *sink
+		attrs []Attr
+	}
+
+	// sink holds the output destination that the log entries will be written to,
+	// the settings shared by a logger and its children,
+	// plus a mutex for coordinating the writes and the changes of the settings.
+	sink struct {
+		mu        sync.Mutex
+		out       io.Writer
+		formatter Formatter
+		traces    [LevelOff]bool // Levels whose entries include a stack trace
+		minLevel  atomic.Int32
+	}
+
+	// Option configures a Logger created by New.
+	Option func(s *sink)
+
+	// contextKey is the key of the logger stored in a context.
+	contextKey struct{}
+)
+
 // New creates a Logger which writes log entries at or above a minimum severity
-// level to a specific output destination.
-func New(out io.Writer, minLevel Level) *Logger {
+// level to a specific output destination. By default the entries are formatted as
+// JSON and the ERROR and FATAL entries include a stack trace.
+func New(out io.Writer, minLevel Level, options ...Option) *Logger {
+	s := &sink{
+		out:       out,
+		formatter: JSONFormatter{},
+	}
+	s.traces[LevelError] = true
+	s.traces[LevelFatal] = true
+	s.minLevel.Store(int32(minLevel))
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return &Logger{sink: s}
+}
+
+// WithFormatter sets the formatter of the log entries.
+func WithFormatter(formatter Formatter) Option {
+	return func(s *sink) {
+		s.formatter = formatter
+	}
+}
+
+// WithStackTraces sets the levels whose entries include a stack trace.
+func WithStackTraces(levels ...Level) Option {
+	return func(s *sink) {
+		s.traces = [LevelOff]bool{}
+		for _, level := range levels {
+			if level >= LevelDebug && level < LevelOff {
+				s.traces[level] = true
+			}
+		}
+	}
+}
+
+// Configure changes the settings of the logger, and of its parent and children,
+// with the options. It's safe to call while the logger is in use.
+func (l *Logger) Configure(options ...Option) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	for _, option := range options {
+		option(l.sink)
+	}
+}
+
+// Level returns the minimum severity level of the entries written.
+func (l *Logger) Level() Level {
+	return Level(l.sink.minLevel.Load())
+}
+
+// SetLevel changes the minimum severity level of the entries written,
+// for the logger as well as its parent and children.
+func (l *Logger) SetLevel(level Level) {
+	l.sink.minLevel.Store(int32(level))
+}
+
+// Enabled reports whether the entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level() && level < LevelOff
+}
+
+// With creates a child Logger which adds the properties to every entry,
+// along with the attributes of its parent.
+func (l *Logger) With(properties map[string]string) *Logger {
+	return l.WithAttrs(propertyAttrs(properties)...)
+}
+
+// WithAttrs creates a child Logger which adds the attributes to every entry,
+// along with the attributes of its parent.
+func (l *Logger) WithAttrs(attrs ...Attr) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		sink:  l.sink,
+		attrs: append(l.attrs[:len(l.attrs):len(l.attrs)], attrs...),
+	}
+}
+
+// NewContext returns a copy of ctx which carries the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or l if there's none.
+// It lets the code running on behalf of a request log with the properties of the request.
+func (l *Logger) FromContext(ctx context.Context) *Logger {
+	if child, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return child
 	}
+
+	return l
+}
+
+// Debug will print a DEBUG level message with the attributes.
+func (l *Logger) Debug(message string, attrs ...Attr) {
+	l.print(LevelDebug, time.Now(), message, attrs)
+}
+
+// Info will print an INFO level message with the attributes.
+func (l *Logger) Info(message string, attrs ...Attr) {
+	l.print(LevelInfo, time.Now(), message, attrs)
+}
+
+// Warn will print a WARN level message with the attributes.
+func (l *Logger) Warn(message string, attrs ...Attr) {
+	l.print(LevelWarn, time.Now(), message, attrs)
+}
+
+// Error will print an ERROR level message with the attributes.
+func (l *Logger) Error(message string, attrs ...Attr) {
+	l.print(LevelError, time.Now(), message, attrs)
 }
 
 // PrintInfo will print an INFO level message.
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, time.Now(), message, propertyAttrs(properties))
 }
 
 // PrintError will print an ERROR level message.
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, time.Now(), err.Error(), propertyAttrs(properties))
 }
 
 // PrintFatal will print a FATAL level message.
 // For this level, the application will be terminated.
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, time.Now(), err.Error(), propertyAttrs(properties))
 	os.Exit(1)
 }
 
 // print writes the log entry.
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+func (l *Logger) print(level Level, t time.Time, message string, attrs []Attr) (int, error) {
+	if !l.Enabled(level) {
 		return 0, nil
 	}
 
-	// Holds data for the log entry.
-	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
-	}{
-		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+	entry := Entry{
+		Level:   level,
+		Time:    t.UTC(),
+		Message: message,
+		// The attributes of the entry come after, and take precedence over,
+		// the ones of the logger.
+		Attrs: append(l.attrs[:len(l.attrs):len(l.attrs)], attrs...),
 	}
 
-	// Include a stack trace for entries at the ERROR and FATAL levels.
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack())
+	l.sink.mu.Lock()
+	formatter, trace := l.sink.formatter, l.sink.traces[level]
+	l.sink.mu.Unlock()
+
+	if trace {
+		entry.Trace = string(debug.Stack())
 	}
 
-	var line []byte
-	line, err := json.Marshal(aux)
+	line, err := formatter.Format(&entry)
 	if err != nil {
-		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+		line = []byte(LevelError.String() + ": unable to format log message: " + err.Error())
+	}
+
+	return l.write(line)
+}
+
+// PrintRaw will print the line as it is, at the INFO level, for the consumers
+// expecting their own format (e.g. the combined format of an access log).
+func (l *Logger) PrintRaw(line string) {
+	if !l.Enabled(LevelInfo) {
+		return
 	}
 
+	l.write([]byte(line))
+}
+
+// write writes the line to the output destination.
+func (l *Logger) write(line []byte) (int, error) {
 	// Lock the mutex so that no two writes to the output destination can happen
 	// concurrently. If we don't do this, it's possible that the text for two or more
 	// log entries will be intermingled in the output.
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
 
 	// Write the log entry followed by a newline.
-	return l.out.Write(append(line, '\n'))
+	return l.sink.out.Write(append(line, '\n'))
 }
 
 // Write method will satisfiy the io.Writer interface.
 // This writes a log entry at the ERROR level with no additional
 // properties.
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	return l.print(LevelError, time.Now(), string(message), nil)
+}
+
+// propertyAttrs converts the properties to string attributes, sorted by key.
+func propertyAttrs(properties map[string]string) []Attr {
+	if len(properties) == 0 {
+		return nil
+	}
+
+	attrs := make([]Attr, 0, len(properties))
+	for key, value := range properties {
+		attrs = append(attrs, String(key, value))
+	}
+
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+
+	return attrs
 }
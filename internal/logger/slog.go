@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// slogHandler is a slog.Handler which writes the records through a Logger.
+type slogHandler struct {
+	logger *Logger
+	groups []string
+	attrs  [][]Attr // Attributes added at every depth of groups, so len(groups)+1 of them
+}
+
+// Handler returns a slog.Handler writing the records through the logger, so the
+// libraries logging with log/slog share its output, level and formatter. The logger
+// carried by the context of a record, if any, is used instead of this one, so the
+// records of a request have the ID of the request.
+func (l *Logger) Handler() slog.Handler {
+	return &slogHandler{logger: l, attrs: make([][]Attr, 1)}
+}
+
+// Slog returns a slog.Logger writing the records through the logger.
+func (l *Logger) Slog() *slog.Logger {
+	return slog.New(l.Handler())
+}
+
+// slogLevel converts the level of a slog record to the closest Level.
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.FromContext(ctx).Enabled(slogLevel(level))
+}
+
+func (h *slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	current := h.attrs[len(h.groups)]
+	attrs := current[:len(current):len(current)]
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+
+	// Nest the attributes in the groups, from the innermost one.
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		group := Group(h.groups[i], attrs...)
+		attrs = append(h.attrs[i][:len(h.attrs[i]):len(h.attrs[i])], group)
+	}
+
+	t := record.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	_, err := h.logger.FromContext(ctx).print(slogLevel(record.Level), t, record.Message, attrs)
+	return err
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	child := h.clone()
+	depth := len(child.groups)
+	child.attrs[depth] = append(child.attrs[depth][:len(child.attrs[depth]):len(child.attrs[depth])], attrs...)
+	return child
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	child := h.clone()
+	child.groups = append(child.groups, name)
+	child.attrs = append(child.attrs, nil)
+	return child
+}
+
+// clone returns a copy of the handler whose slices can be appended to.
+func (h *slogHandler) clone() *slogHandler {
+	return &slogHandler{
+		logger: h.logger,
+		groups: h.groups[:len(h.groups):len(h.groups)],
+		attrs:  append([][]Attr(nil), h.attrs...),
+	}
+}
@@ -0,0 +1,341 @@
+// Package metrics is a small implementation of counters, gauges and histograms,
+// exposed in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the buckets of a latency histogram.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type (
+	// Registry holds the metrics to expose.
+	Registry struct {
+		mu      sync.Mutex
+		metrics []metric
+	}
+
+	// metric is a named family of series.
+	metric interface {
+		write(w *bufio.Writer)
+	}
+
+	// family holds the series of a metric, one for every set of label values.
+	family struct {
+		name   string
+		help   string
+		kind   string
+		labels []string
+
+		mu     sync.Mutex
+		series map[string]*series
+	}
+
+	// series holds the values of a metric for a set of label values.
+	series struct {
+		labels []string
+		value  float64
+		// The histograms count the observations of every bucket and sum them.
+		buckets []uint64
+		count   uint64
+	}
+
+	// Counter is a metric whose value only goes up.
+	Counter struct {
+		family
+	}
+
+	// Gauge is a metric whose value goes up and down.
+	Gauge struct {
+		family
+	}
+
+	// Histogram is a metric counting observations in buckets.
+	Histogram struct {
+		family
+		bounds []float64
+	}
+
+	// funcMetric is a metric without labels whose value is read when it is exposed.
+	funcMetric struct {
+		name string
+		help string
+		kind string
+		fn   func() float64
+	}
+)
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a new counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewGauge registers a new gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers a new histogram with the given bucket upper bounds,
+// in increasing order, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{family: newFamily(name, help, "histogram", labels), bounds: buckets}
+	r.register(h)
+	return h
+}
+
+// NewCounterFunc registers a new counter whose value is returned by fn.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", fn: fn})
+}
+
+// NewGaugeFunc registers a new gauge whose value is returned by fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Inc increments the counter of the label values by 1.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label values.
+func (c *Counter) Add(v float64, labels ...string) {
+	c.update(labels, func(s *series) {
+		s.value += v
+	})
+}
+
+// Inc increments the gauge of the label values by 1.
+func (g *Gauge) Inc(labels ...string) {
+	g.Add(1, labels...)
+}
+
+// Dec decrements the gauge of the label values by 1.
+func (g *Gauge) Dec(labels ...string) {
+	g.Add(-1, labels...)
+}
+
+// Add adds v to the gauge of the label values.
+func (g *Gauge) Add(v float64, labels ...string) {
+	g.update(labels, func(s *series) {
+		s.value += v
+	})
+}
+
+// Set sets the gauge of the label values to v.
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.update(labels, func(s *series) {
+		s.value = v
+	})
+}
+
+// Observe adds an observation to the histogram of the label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.update(labels, func(s *series) {
+		if s.buckets == nil {
+			s.buckets = make([]uint64, len(h.bounds))
+		}
+		for i, bound := range h.bounds {
+			if v <= bound {
+				s.buckets[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+// update calls fn with the series of the label values, which is created if needed.
+func (f *family) update(labels []string, fn func(s *series)) {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		f.series[key] = s
+	}
+
+	fn(s)
+}
+
+// sortedSeries returns copies of the series, sorted by their label values.
+func (f *family) sortedSeries() []series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	all := make([]series, len(keys))
+	for i, key := range keys {
+		s := *f.series[key]
+		s.buckets = append([]uint64(nil), s.buckets...)
+		all[i] = s
+	}
+
+	return all
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	for _, s := range c.sortedSeries() {
+		writeSample(w, c.name, c.labels, s.labels, "", "", s.value)
+	}
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	for _, s := range g.sortedSeries() {
+		writeSample(w, g.name, g.labels, s.labels, "", "", s.value)
+	}
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	for _, s := range h.sortedSeries() {
+		for i, bound := range h.bounds {
+			var count uint64
+			if s.buckets != nil {
+				count = s.buckets[i]
+			}
+			writeSample(w, h.name+"_bucket", h.labels, s.labels, "le", formatFloat(bound), float64(count))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.labels, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labels, "", "", s.value)
+		writeSample(w, h.name+"_count", h.labels, s.labels, "", "", float64(s.count))
+	}
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, m.name, m.help, m.kind)
+	writeSample(w, m.name, nil, nil, "", "", m.fn())
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes a sample line, with the extra label if its name isn't empty.
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+
+	if len(labelNames) > 0 || extraName != "" {
+		escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+		w.WriteByte('{')
+		for i, label := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escape.Replace(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, escape.Replace(extraValue))
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -18,25 +18,56 @@ import (
 // then convert it to an integer and return it.
 // If the operation isn't successful, return 0 and an error.
 func ReadIDParam(r *http.Request) (int64, error) {
+	return ReadInt64Param(r, "id")
+}
+
+// ReadInt64Param retrieves the URL parameter with the given name from the current
+// request context, then convert it to a positive integer and return it.
+// If the operation isn't successful, return 0 and an error.
+func ReadInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	value, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
-	return id, nil
+	return value, nil
 }
 
+// maxBodyBytes limits the size of the request bodies read in full.
+const maxBodyBytes = 1_048_576
+
 // ReadJSON will decode the JSON from the request body as normal,
 // then triage the errors and replace them with our own
 // custom messages as necessary.
 func ReadJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	// Limit the size of the request body to 1MB.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	return DecodeJSON(r.Body, dst)
+}
+
+// ReadBody reads the whole request body, up to the same 1MB limit as ReadJSON.
+func ReadBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return nil, errors.New("body must not be empty")
+	}
+
+	return body, nil
+}
 
-	dec := json.NewDecoder(r.Body)
+// DecodeJSON decodes a single JSON value from src into dst, with the same
+// rules and error messages as ReadJSON.
+func DecodeJSON(src io.Reader, dst any) error {
+	dec := json.NewDecoder(src)
 	dec.DisallowUnknownFields()
 
 	err := dec.Decode(dst)
@@ -139,3 +170,100 @@ func ReadInt(qs url.Values, key string, defaultValue int, v *validator.Validator
 
 	return i
 }
+
+// ReadBool reads a string value from the query string and converts it to a
+// boolean before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to a boolean, then we record an
+// error message in the provided Validator instance.
+func ReadBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// ETagMatches reports whether etag matches one of the entity tags listed in the value of
+// an If-Match or If-None-Match header. The "*" value matches any etag.
+// The If-Match header requires a strong comparison, so weak tags (prefixed by "W/") match
+// only when weak is true, as done by the weak comparison of the If-None-Match header.
+func ETagMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Accepts reports whether the Accept header of the request explicitly lists the media type
+// with a non-zero quality. Wildcards are not taken into account, so that clients opt in to
+// the media type.
+func Accepts(r *http.Request, mediaType string) bool {
+	return accepts(r, func(name string) bool {
+		return strings.EqualFold(name, mediaType)
+	})
+}
+
+// AcceptsAny reports whether the request accepts the media type, either explicitly or
+// through a wildcard ("*/*" or "type/*") with a non-zero quality. The requests without
+// an Accept header accept any media type.
+func AcceptsAny(r *http.Request, mediaType string) bool {
+	if len(r.Header.Values("Accept")) == 0 {
+		return true
+	}
+
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	return accepts(r, func(name string) bool {
+		return name == "*/*" || strings.EqualFold(name, mainType+"/*") || strings.EqualFold(name, mediaType)
+	})
+}
+
+// accepts reports whether the Accept header of the request lists a media range
+// matching match with a non-zero quality.
+func accepts(r *http.Request, match func(name string) bool) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			if !match(strings.TrimSpace(name)) {
+				continue
+			}
+
+			for _, param := range strings.Split(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if key == "q" {
+					if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+						return false
+					}
+				}
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,10 +3,50 @@ package web
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
 )
 
-// Used as an envelope type in the http response.
-type Envelope map[string]any
+// ProblemContentType is the media type of the RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+type (
+	// Used as an envelope type in the http response.
+	Envelope map[string]any
+
+	// Problem represents the RFC 7807 problem details of an error response,
+	// extended with a machine-readable error code.
+	Problem struct {
+		Type          string         `json:"type"`
+		Title         string         `json:"title"`
+		Status        int            `json:"status"`
+		Detail        string         `json:"detail,omitempty"`
+		Instance      string         `json:"instance,omitempty"`
+		Code          string         `json:"code"`
+		RequestID     string         `json:"request_id,omitempty"`
+		InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+	}
+
+	// InvalidParam describes why a request parameter failed the validation.
+	InvalidParam struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+)
+
+// NewInvalidParams converts validation errors, keyed by parameter name, into
+// invalid params sorted by name.
+func NewInvalidParams(errors map[string]string) []InvalidParam {
+	params := make([]InvalidParam, 0, len(errors))
+	for name, reason := range errors {
+		params = append(params, InvalidParam{Name: name, Reason: reason})
+	}
+
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].Name < params[j].Name
+	})
+
+	return params
+}
 
 // WriteJSON takes the destination http.ResponseWriter, the HTTP status code to send,
 //
@@ -37,3 +77,24 @@ func WriteJSON(w http.ResponseWriter, status int, data Envelope, headers http.He
 
 	return nil
 }
+
+// WriteProblem writes the problem details with the "application/problem+json"
+// content type and the status code of the problem.
+func WriteProblem(w http.ResponseWriter, problem Problem, headers http.Header) error {
+	js, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	w.Write(js)
+
+	return nil
+}
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    rating integer NOT NULL,
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 10),
+    CONSTRAINT reviews_user_movie_key UNIQUE (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_idx ON reviews (movie_id);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating double precision NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS review_count integer NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS watchlist_items;
+DROP TABLE IF EXISTS watchlists;
\ No newline at end of file
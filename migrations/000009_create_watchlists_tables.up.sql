@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS watchlists (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT watchlists_user_name_key UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS watchlist_items (
+    watchlist_id bigint NOT NULL REFERENCES watchlists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watched_at timestamp(0) with time zone,
+    PRIMARY KEY (watchlist_id, movie_id),
+    -- Deferred, so the positions can be shifted by a single UPDATE.
+    CONSTRAINT watchlist_items_position_key UNIQUE (watchlist_id, position) DEFERRABLE INITIALLY DEFERRED,
+    CONSTRAINT watchlist_items_position_check CHECK (position > 0)
+);
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    format text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    accepted integer NOT NULL DEFAULT 0,
+    rejected integer NOT NULL DEFAULT 0,
+    report jsonb NOT NULL DEFAULT '[]',
+    error text NOT NULL DEFAULT ''
+);
\ No newline at end of file
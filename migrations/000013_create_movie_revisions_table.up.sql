@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    operation text NOT NULL,
+    snapshot jsonb NOT NULL,
+    changes jsonb NOT NULL DEFAULT '{}',
+    changed_by bigint REFERENCES users ON DELETE SET NULL,
+    PRIMARY KEY (movie_id, version),
+    CONSTRAINT movie_revisions_operation_check CHECK (operation IN ('create', 'update', 'delete', 'restore', 'revert'))
+);
+
+-- The history of the existing movies starts with their current state.
+INSERT INTO movie_revisions (movie_id, version, created_at, operation, snapshot)
+SELECT id, version, created_at, 'create', jsonb_build_object(
+    'title', title,
+    'year', year,
+    'runtime', runtime || ' mins',
+    'genres', genres,
+    'deleted_at', deleted_at)
+FROM movies
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS request_id text NOT NULL DEFAULT '';
\ No newline at end of file
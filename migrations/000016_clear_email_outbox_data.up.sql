@@ -0,0 +1,4 @@
+ALTER TABLE email_outbox ALTER COLUMN data DROP NOT NULL;
+
+-- The data of the emails holds the plaintext tokens, which are only needed until the email is sent.
+UPDATE email_outbox SET data = NULL WHERE status IN ('sent', 'dead');
\ No newline at end of file
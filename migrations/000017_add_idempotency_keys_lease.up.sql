@@ -0,0 +1 @@
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until timestamp(0) with time zone NOT NULL DEFAULT NOW();
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE import_jobs
+    DROP COLUMN IF EXISTS owner,
+    DROP COLUMN IF EXISTS locked_until;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE import_jobs
+    ADD COLUMN IF NOT EXISTS owner text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS locked_until timestamp(0) with time zone NOT NULL DEFAULT NOW();
\ No newline at end of file
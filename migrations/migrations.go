@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migration files, so they ship with the binary.
+package migrations
+
+import "embed"
+
+// FS holds the up and down SQL files, named "<version>_<name>.<up|down>.sql".
+//
+//go:embed *.sql
+var FS embed.FS